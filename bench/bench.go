@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lblclass/hnswgo/hnsw"
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Searcher is the part of the public search API the harness drives. Both
+// *hnsw.HNSW and *hnsw.SafeHNSW satisfy it.
+type Searcher interface {
+	KNNSearchWithEf(q models.Element, K, ef int) []int
+}
+
+// Result summarizes one BenchmarkSearch run. Latencies are per query.
+type Result struct {
+	Queries int
+	Total   time.Duration
+	Mean    time.Duration
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+	QPS     float64
+}
+
+// BenchmarkSearch runs every query once, sequentially, against index and
+// reports latency percentiles and throughput. Percentiles use the
+// nearest-rank method over the individual query timings.
+func BenchmarkSearch(index Searcher, queries []models.Element, K, ef int) Result {
+	if len(queries) == 0 {
+		return Result{}
+	}
+	latencies := make([]time.Duration, len(queries))
+	start := time.Now()
+	for i, q := range queries {
+		t := time.Now()
+		index.KNNSearchWithEf(q, K, ef)
+		latencies[i] = time.Since(t)
+	}
+	total := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	res := Result{
+		Queries: len(queries),
+		Total:   total,
+		Mean:    sum / time.Duration(len(latencies)),
+		P50:     percentile(latencies, 50),
+		P95:     percentile(latencies, 95),
+		P99:     percentile(latencies, 99),
+		Max:     latencies[len(latencies)-1],
+	}
+	if total > 0 {
+		res.QPS = float64(len(queries)) / total.Seconds()
+	}
+	return res
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// BuildResult compares the wall time of two ways to build the same index.
+type BuildResult struct {
+	Elements int
+	Insert   time.Duration // NewHNSW followed by InsertBatch
+	Bulk     time.Duration // BuildBulk
+}
+
+// BenchmarkBuild builds an index from elems twice, once by repeated Insert
+// and once with BuildBulk, using the same parameters and options.
+func BenchmarkBuild(efConstruction, M, maxLayers int, nm float64, elems []models.Element, opts ...hnsw.Option) (BuildResult, error) {
+	res := BuildResult{Elements: len(elems)}
+	start := time.Now()
+	if err := hnsw.NewHNSW(efConstruction, M, maxLayers, nm, opts...).InsertBatch(elems); err != nil {
+		return res, err
+	}
+	res.Insert = time.Since(start)
+	start = time.Now()
+	if _, err := hnsw.BuildBulk(efConstruction, M, maxLayers, nm, elems, opts...); err != nil {
+		return res, err
+	}
+	res.Bulk = time.Since(start)
+	return res, nil
+}
@@ -0,0 +1,137 @@
+package hnsw
+
+import "github.com/lblclass/hnswgo/models"
+
+// Adaptive build. AdaptiveBuild inserts elements like InsertBatch and, at
+// regular checkpoints, measures recall@K on a few of the elements still to
+// be inserted, against BruteForceKNN. A BuildPolicy turns each measurement
+// into the construction parameters used for the following inserts, which
+// closes the loop:
+//
+//	insert Interval elements -> measure recall -> policy -> SetBuildParams
+//
+// New parameters only shape the nodes inserted after them, plus the links
+// back to those nodes, so the graph built so far keeps its density and the
+// measured recall follows a change with a lag. The default policy therefore
+// only ever raises M and EfConstruction, and a policy that also lowers them
+// should leave a margin around its target to avoid oscillating.
+//
+// Each checkpoint costs Queries graph searches and Queries brute-force
+// scans of the index; with the defaults that is 20 scans per 1000 inserts.
+
+// BuildParams are the construction parameters AdaptiveBuild can change
+// during a build.
+type BuildParams struct {
+	M              int // Neighbors selected per insert; nodes keep up to 2*M
+	EfConstruction int // Candidate list size of inserts
+}
+
+// BuildCheck is the outcome of one AdaptiveBuild checkpoint.
+type BuildCheck struct {
+	Inserted int         // Elements inserted before the check
+	Recall   float64     // Measured recall@K
+	Params   BuildParams // Parameters chosen for the following inserts
+}
+
+// BuildPolicy picks the construction parameters for the next inserts from
+// the recall measured at a checkpoint and the current parameters.
+type BuildPolicy func(recall float64, current BuildParams) BuildParams
+
+// AdaptiveBuildConfig configures AdaptiveBuild. Zero fields take the
+// defaults given.
+type AdaptiveBuildConfig struct {
+	Interval     int         // Inserts between checkpoints; 0 is 1000
+	Queries      int         // Held-out queries per checkpoint; 0 is 20
+	K            int         // Recall is measured at K; 0 is 10
+	Ef           int         // Search ef of the checks; 0 is the initial EfConstruction
+	TargetRecall float64     // Default policy: raise the parameters below this recall
+	MaxM         int         // Default policy: upper bound of M; 0 is 4 times the initial M
+	Policy       BuildPolicy // Replaces the default policy when set
+}
+
+// BuildParams returns the current construction parameters.
+func (h *HNSW) BuildParams() BuildParams {
+	return BuildParams{M: h.M, EfConstruction: h.EfConstruction}
+}
+
+// SetBuildParams sets the construction parameters of subsequent inserts.
+// The connection limit of every node becomes 2*p.M; lists already longer
+// than a lowered limit are kept until CapDegrees trims them. Non-positive
+// fields are ignored.
+func (h *HNSW) SetBuildParams(p BuildParams) {
+	if p.M > 0 {
+		h.M = p.M
+		h.maxConnections = 2 * p.M
+	}
+	if p.EfConstruction > 0 {
+		h.EfConstruction = p.EfConstruction
+	}
+}
+
+// RaiseBelowTarget returns the default policy of AdaptiveBuild: whenever
+// recall is below target, M grows by a quarter, at least by one and at most
+// to maxM, and EfConstruction grows in proportion. Parameters are never
+// lowered.
+func RaiseBelowTarget(target float64, maxM int) BuildPolicy {
+	return func(recall float64, p BuildParams) BuildParams {
+		if recall >= target || p.M >= maxM {
+			return p
+		}
+		m := min(p.M+max(p.M/4, 1), maxM)
+		return BuildParams{M: m, EfConstruction: p.EfConstruction * m / p.M}
+	}
+}
+
+// AdaptiveBuild inserts elems in order like InsertBatch and adjusts M and
+// EfConstruction at checkpoints every cfg.Interval inserts, as described
+// above. The queries of a checkpoint are the next cfg.Queries elements of
+// elems, measured before they are inserted, so they follow the data as it
+// arrives. It returns the checkpoints in order and stops at the first
+// element Insert rejects.
+func (h *HNSW) AdaptiveBuild(elems []models.Element, cfg AdaptiveBuildConfig) ([]BuildCheck, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 1000
+	}
+	if cfg.Queries <= 0 {
+		cfg.Queries = 20
+	}
+	if cfg.K <= 0 {
+		cfg.K = 10
+	}
+	if cfg.Ef <= 0 {
+		cfg.Ef = h.EfConstruction
+	}
+	if cfg.MaxM <= 0 {
+		cfg.MaxM = 4 * h.M
+	}
+	policy := cfg.Policy
+	if policy == nil {
+		policy = RaiseBelowTarget(cfg.TargetRecall, cfg.MaxM)
+	}
+	var checks []BuildCheck
+	for i, e := range elems {
+		if i > 0 && i%cfg.Interval == 0 {
+			queries := elems[i:min(i+cfg.Queries, len(elems))]
+			recall := h.sampleRecall(queries, cfg.K, cfg.Ef)
+			h.SetBuildParams(policy(recall, h.BuildParams()))
+			checks = append(checks, BuildCheck{Inserted: i, Recall: recall, Params: h.BuildParams()})
+		}
+		if err := h.Insert(e); err != nil {
+			return checks, err
+		}
+		h.reportProgress(i+1, len(elems))
+	}
+	return checks, nil
+}
+
+// sampleRecall returns the mean recall@K of queries at the given ef against
+// BruteForceKNN. The queries are projected like inserted vectors.
+func (h *HNSW) sampleRecall(queries []models.Element, K, ef int) float64 {
+	projected := make([]models.Element, len(queries))
+	truth := make([][]int, len(queries))
+	for i, q := range queries {
+		projected[i] = models.Element{Embeddings: h.Project(q.Embeddings)}
+		truth[i] = h.BruteForceKNN(projected[i], K)
+	}
+	return h.EvaluateRecall(projected, truth, K, ef)
+}
@@ -0,0 +1,45 @@
+package hnsw
+
+import "github.com/lblclass/hnswgo/models"
+
+// BatchDistanceFunc computes the distances from q to each of candidates
+// under the index metric and returns them in the order of candidates, e.g.
+// on a GPU. Layer searches call it once per expanded node with the vectors
+// of all its unvisited neighbors, up to 2*M of them, instead of computing
+// the distances one by one. For Cosine it must return 1 - cos, not the
+// similarity, and for Angular the angle in radians.
+//
+// The candidate vectors are the stored ones and must not be modified or
+// kept. The function is called from the searching goroutine, so with a
+// SafeHNSW it may run concurrently with itself.
+type BatchDistanceFunc func(q []float64, candidates [][]float64) []float64
+
+// ScalarBatchDistance returns a BatchDistanceFunc computing the distances
+// of metric m one at a time on the CPU, the reference an accelerated
+// implementation should agree with. Integer metrics are not supported.
+func ScalarBatchDistance(m Metric) BatchDistanceFunc {
+	ref := &HNSW{Metric: m} // Only the metric is read
+	return func(q []float64, candidates [][]float64) []float64 {
+		res := make([]float64, len(candidates))
+		for i, c := range candidates {
+			res[i] = ref.vectorDistance(q, c)
+		}
+		return res
+	}
+}
+
+// batchQueryDistance returns a function computing the distances from q to
+// stored elements by ID through the WithBatchDistance function, or nil if
+// none is set or the metric is an integer one.
+func (h *HNSW) batchQueryDistance(q models.Element) func(ids []int) []float64 {
+	if h.batchDist == nil || h.Metric.isInt() {
+		return nil
+	}
+	return func(ids []int) []float64 {
+		vectors := make([][]float64, len(ids))
+		for i, id := range ids {
+			vectors[i] = h.vector(id)
+		}
+		return h.batchDist(q.Embeddings, vectors)
+	}
+}
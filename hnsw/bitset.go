@@ -0,0 +1,105 @@
+package hnsw
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// IDSet is a set of element IDs tested during a filtered search. Its method
+// matches that of *roaring.Bitmap from github.com/RoaringBitmap/roaring, so
+// a roaring bitmap can be passed as is; Bitset is a dependency-free
+// implementation. IDs outside the uint32 range are never in a set.
+// Contains is called once per node the search reaches and should be O(1).
+type IDSet interface {
+	Contains(id uint32) bool
+}
+
+// Bitset is a plain IDSet holding ID i in bit i%64 of word i/64. It costs
+// one bit per ID up to the largest ID, e.g. 125 KB for IDs below one
+// million, whatever the number of IDs in the set.
+type Bitset []uint64
+
+// NewBitset returns a Bitset holding ids. Negative IDs and IDs beyond the
+// uint32 range are ignored.
+func NewBitset(ids []int) Bitset {
+	var b Bitset
+	for _, id := range ids {
+		b.Add(id)
+	}
+	return b
+}
+
+// Add adds id to the set, growing it as needed. Negative IDs and IDs beyond
+// the uint32 range are ignored.
+func (b *Bitset) Add(id int) {
+	if id < 0 || uint64(id) > math.MaxUint32 {
+		return
+	}
+	w := id / 64
+	if w >= len(*b) {
+		*b = append(*b, make(Bitset, w+1-len(*b))...)
+	}
+	(*b)[w] |= 1 << (id % 64)
+}
+
+// Contains reports whether id is in the set.
+func (b Bitset) Contains(id uint32) bool {
+	w := int(id / 64)
+	return w < len(b) && b[w]&(1<<(id%64)) != 0
+}
+
+// GetCardinality returns the number of IDs in the set.
+func (b Bitset) GetCardinality() uint64 {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return uint64(n)
+}
+
+// ToArray returns the IDs in the set in ascending order.
+func (b Bitset) ToArray() []uint32 {
+	res := make([]uint32, 0, b.GetCardinality())
+	for i, w := range b {
+		for w != 0 {
+			res = append(res, uint32(i*64+bits.TrailingZeros64(w)))
+			w &= w - 1
+		}
+	}
+	return res
+}
+
+// listedSet is an IDSet that can report its size and list its IDs, as
+// Bitset and roaring bitmaps can.
+type listedSet interface {
+	IDSet
+	GetCardinality() uint64
+	ToArray() []uint32
+}
+
+// KNNSearchBitset is KNNSearchWithEf restricted to the elements in allowed.
+// Like KNNSearchAmong, it runs a graph search that traverses every node but
+// only returns allowed ones, so ef counts allowed elements, and it scans
+// sets up to the WithAmongThreshold size exactly, provided they can list
+// their IDs: Bitset and roaring bitmaps can, through GetCardinality and
+// ToArray. Unlike KNNSearchAmong, it tests membership in O(1) without
+// building a set from a slice, which suits sets of millions of IDs kept
+// across queries. The graph search walks further through excluded nodes the
+// more selective the set, so its cost rises steeply for sets of a few
+// percent of the index that are too large to scan.
+func (h *HNSW) KNNSearchBitset(q models.Element, K, ef int, allowed IDSet) []int {
+	if s, ok := allowed.(listedSet); ok && s.GetCardinality() <= uint64(h.amongLimit()) {
+		members := s.ToArray()
+		ids := make([]int, len(members))
+		for i, id := range members {
+			ids[i] = int(id)
+		}
+		return candidateIDs(h.exactKNN(q, K, ids))
+	}
+	opts := &layerSearch{filter: func(id int) bool {
+		return id >= 0 && uint64(id) <= math.MaxUint32 && allowed.Contains(uint32(id))
+	}}
+	return candidateIDs(h.searchKNN(q, K, ef, opts))
+}
@@ -0,0 +1,62 @@
+package hnsw
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+func TestBitset(t *testing.T) {
+	b := NewBitset([]int{3, 64, 200, -1, 3})
+	b.Add(math.MaxInt)
+	if got := b.ToArray(); !slices.Equal(got, []uint32{3, 64, 200}) {
+		t.Errorf("ToArray = %v, want [3 64 200]", got)
+	}
+	if b.GetCardinality() != 3 || !b.Contains(64) || b.Contains(65) || b.Contains(1<<20) {
+		t.Errorf("Bitset %v: wrong cardinality or membership", b.ToArray())
+	}
+}
+
+// TestKNNSearchBitsetRecall checks a filtered search with a set of a tenth
+// of the index against a brute-force scan over the allowed IDs, both
+// through the graph and through the exact scan of small sets.
+func TestKNNSearchBitsetRecall(t *testing.T) {
+	const n, d, K, ef = 2000, 16, 10, 50
+	r := rand.New(rand.NewSource(17))
+	var allowed []int
+	for id := 0; id < n; id += 10 {
+		allowed = append(allowed, id)
+	}
+	set := NewBitset(allowed)
+	for _, threshold := range []int{1, len(allowed)} {
+		h := buildRandom(t, n, d, 18, WithAmongThreshold(threshold))
+		hits, total := 0, 0
+		for i := 0; i < 50; i++ {
+			q := models.Element{Embeddings: randomVector(r, d)}
+			res := h.KNNSearchBitset(q, K, ef, set)
+			if len(res) != K {
+				t.Fatalf("threshold %d: got %d results, want %d", threshold, len(res), K)
+			}
+			want := candidateIDs(h.exactKNN(q, K, allowed))
+			for _, id := range res {
+				if id%10 != 0 {
+					t.Fatalf("threshold %d: result %d is not allowed", threshold, id)
+				}
+				if slices.Contains(want, id) {
+					hits++
+				}
+			}
+			total += K
+		}
+		recall := float64(hits) / float64(total)
+		if threshold == len(allowed) && recall != 1 {
+			t.Errorf("exact scan: recall %.3f, want 1", recall)
+		}
+		if recall < 0.9 {
+			t.Errorf("threshold %d: recall %.3f, want at least 0.9", threshold, recall)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package hnsw
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// BuildBulk creates an index from a static set of elements. It takes the
+// same parameters and options as NewHNSW.
+//
+// Levels are assigned to all elements up front, and the graph is then built
+// one layer at a time, from the top down. Each layer starts as a copy of the
+// finished layer above it, which is already the navigable graph of the nodes
+// reaching higher, and the nodes whose top level is this layer are linked
+// into it. A node is therefore searched for and linked once, at its own top
+// layer, instead of once per layer as with Insert. Every descent also runs
+// through finished upper layers. Elements are validated like Insert; IDs
+// must be unique.
+func BuildBulk(efConstruction, M, maxLayers int, nm float64, elems []models.Element, opts ...Option) (*HNSW, error) {
+	h := NewHNSW(efConstruction, M, maxLayers, nm, opts...)
+	if h.maxElements > 0 && len(elems) > h.maxElements {
+		return nil, fmt.Errorf("hnsw: %d elements exceed the limit of %d", len(elems), h.maxElements)
+	}
+	nodes := make([]models.Element, 0, len(elems))
+	for _, e := range elems {
+		e, err := h.prepare(e)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := h.Elements[e.ID]; ok {
+			return nil, fmt.Errorf("element %d: %w", e.ID, ErrDuplicateID)
+		}
+		if h.Dim == 0 {
+			h.Dim = len(e.Embeddings)
+		}
+		h.store(e)
+		h.Levels[e.ID] = min(h.generateLevel(), h.MaxLayers)
+		nodes = append(nodes, e)
+	}
+	if len(nodes) == 0 {
+		return h, nil
+	}
+	// Highest levels first; ties keep the input order.
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return h.Levels[nodes[i].ID] > h.Levels[nodes[j].ID]
+	})
+
+	top := h.Levels[nodes[0].ID]
+	h.Layers = make([]map[int]*hnswheap.CandidateHeap, top+1)
+	h.EnterPoint = nodes[0].ID
+	next := 0
+	for lc := top; lc >= 0; lc-- {
+		h.Layers[lc] = make(map[int]*hnswheap.CandidateHeap)
+		if lc < top {
+			for id, conns := range h.Layers[lc+1] {
+				h.Layers[lc][id] = copyHeap(conns)
+			}
+		}
+		for ; next < len(nodes) && h.Levels[nodes[next].ID] == lc; next++ {
+			h.linkBulk(nodes[next], lc)
+		}
+	}
+	if h.deferPruning {
+		h.pruneAll()
+	}
+	return h, nil
+}
+
+// linkBulk links q into layer lc of a bulk build, descending from the entry
+// point through the finished layers above.
+func (h *HNSW) linkBulk(q models.Element, lc int) {
+	if len(h.Layers[lc]) == 0 {
+		h.Layers[lc][q.ID] = hnswheap.NewBigCandidatesHeap()
+		return
+	}
+	opts := &layerSearch{dist: h.queryDistance(q)}
+	ep := h.EnterPoint
+	for l := len(h.Layers) - 1; l > lc; l-- {
+		if W := h.searchLayer(q, ep, 1, l, opts); W.Len() > 0 {
+			ep = W.Candidates[0].NodeID
+		}
+	}
+	h.Layers[lc][q.ID] = hnswheap.NewBigCandidatesHeap()
+	neighbors := h.searchLayer(q, ep, h.EfConstruction, lc, opts).ExtractHeapData()
+	for _, n := range h.SelectNeighborsHeuristic(q, neighbors, h.M, lc, true, true) {
+		if h.deferPruning {
+			h.addConnectionNoPrune(n, q.ID, lc)
+		} else {
+			h.addConnection(n, q.ID, lc)
+		}
+		h.addConnection(q.ID, n, lc)
+	}
+}
+
+// addConnectionNoPrune is addConnection without eviction: a full list
+// grows instead of dropping its farthest connection. pruneAll trims the
+// lists afterwards.
+func (h *HNSW) addConnectionNoPrune(from, to, layer int) {
+	heap.Push(h.Layers[layer][from], models.Candidate{NodeID: to, Distance: h.nodeDistance(from, to)})
+}
+
+// pruneAll trims every connection list longer than maxConnections.
+func (h *HNSW) pruneAll() {
+	h.pruneLists(h.maxConnections)
+}
+
+// copyHeap returns an independent copy of a connection heap.
+func copyHeap(src *hnswheap.CandidateHeap) *hnswheap.CandidateHeap {
+	c := hnswheap.NewBigCandidatesHeap()
+	c.Candidates = append([]models.Candidate(nil), src.Candidates...)
+	heap.Init(c)
+	return c
+}
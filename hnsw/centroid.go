@@ -0,0 +1,67 @@
+package hnsw
+
+import (
+	"math"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Centroid returns the mean of the vectors of all elements that are not
+// deleted, with the index dimension, or nil if there are none. Integer
+// vectors are averaged as float64. It costs O(N*dim).
+func (h *HNSW) Centroid() []float64 {
+	var centroid []float64
+	n := 0
+	for _, id := range sortedKeys(h.Elements) {
+		if h.Deleted[id] {
+			continue
+		}
+		v := h.vector(id)
+		if centroid == nil {
+			centroid = make([]float64, len(v))
+		}
+		for i, x := range v {
+			centroid[i] += x
+		}
+		n++
+	}
+	for i := range centroid {
+		centroid[i] /= float64(n)
+	}
+	return centroid
+}
+
+// Medoid returns the ID of the element nearest the centroid under the index
+// metric, the lowest ID on ties, or -1 if the index holds no element that
+// is not deleted. Being a stored vector close to the bulk of the data, it
+// is a robust starting point for searches and a representative for
+// clustering. Integer indexes use Euclidean distance. It costs O(N*dim).
+func (h *HNSW) Medoid() int {
+	centroid := h.Centroid()
+	if centroid == nil {
+		return -1
+	}
+	dist := h.centroidDistance(centroid)
+	medoid, nearest := -1, math.Inf(1)
+	for _, id := range sortedKeys(h.Elements) {
+		if h.Deleted[id] {
+			continue
+		}
+		if d := dist(id); d < nearest {
+			medoid, nearest = id, d
+		}
+	}
+	return medoid
+}
+
+// centroidDistance returns the distance from centroid to stored elements
+// by ID. Integer indexes have no float query path and use Euclidean
+// distance.
+func (h *HNSW) centroidDistance(centroid []float64) func(id int) float64 {
+	if h.Metric.isInt() {
+		return func(id int) float64 {
+			return math.Sqrt(squaredL2(centroid, h.vector(id)))
+		}
+	}
+	return h.queryDistance(models.Element{Embeddings: centroid})
+}
@@ -0,0 +1,128 @@
+package hnsw
+
+import (
+	"container/heap"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// SearchCursor walks the neighbors of a query in approximately ascending
+// distance order, a batch at a time, without fixing K up front. It keeps
+// the layer 0 search state between calls to Next, so each batch continues
+// where the previous one stopped instead of searching again with a larger
+// ef.
+//
+// A cursor holds every node it has reached: the visited set, the frontier
+// of reached but unexpanded nodes, and the pool of found but unreturned
+// results, each entry an ID and a distance. Memory grows with the number
+// of nodes visited, which is a few times the number returned, and is only
+// released when the cursor is dropped.
+//
+// A cursor reads the index on every call to Next, which must not overlap
+// with changes to it; with SafeHNSW, create and advance cursors inside
+// View. Results come from the graph metric and are not re-ranked by
+// WithRefineDistance.
+type SearchCursor struct {
+	h        *HNSW
+	ef       int
+	opts     *layerSearch
+	visited  map[int]bool
+	frontier *hnswheap.CandidateHeap // Reached, not yet expanded; closest first
+	pool     *hnswheap.CandidateHeap // Found, not yet returned; closest first
+}
+
+// KNNSearchCursor returns a cursor over the neighbors of q. ef is the
+// lookahead kept beyond the results returned so far: a first call to
+// Next(n) with n <= ef finds what KNNSearchWithEf(q, n, ef) finds, and
+// each later call searches ef further. Deleted and hidden elements are
+// skipped.
+func (h *HNSW) KNNSearchCursor(q models.Element, ef int) *SearchCursor {
+	c := &SearchCursor{
+		h:        h,
+		ef:       max(ef, 1),
+		opts:     &layerSearch{skipDeleted: true, dist: h.queryDistance(q)},
+		visited:  map[int]bool{},
+		frontier: hnswheap.NewSmallCandidatesHeap(),
+		pool:     hnswheap.NewSmallCandidatesHeap(),
+	}
+	if h.EnterPoint < 0 || len(h.Layers) == 0 {
+		return c
+	}
+	upper := &layerSearch{dist: c.opts.dist}
+	ep := h.EnterPoint
+	for lc := len(h.Layers) - 1; lc >= 1; lc-- {
+		if W := h.searchLayer(q, ep, 1, lc, upper); W.Len() > 0 {
+			ep = nearest(W.Candidates)
+		}
+	}
+	if _, ok := h.Layers[0][ep]; ok {
+		c.reach(ep)
+	}
+	return c
+}
+
+// Next returns up to n further neighbors in ascending distance order, none
+// of them returned before. It returns fewer than n only once the search
+// has exhausted the part of the graph it can reach.
+func (c *SearchCursor) Next(n int) []models.Candidate {
+	if n <= 0 {
+		return nil
+	}
+	c.expand(max(c.ef, n))
+	res := make([]models.Candidate, 0, min(n, c.pool.Len()))
+	for len(res) < n && c.pool.Len() > 0 {
+		res = append(res, heap.Pop(c.pool).(models.Candidate))
+	}
+	return res
+}
+
+// expand runs the layer 0 search until the closest unexpanded node is
+// farther than the k closest unreturned results, the stopping rule of
+// searchLayer applied to what is left of the pool.
+func (c *SearchCursor) expand(k int) {
+	W := hnswheap.NewBigCandidatesHeap()
+	for _, r := range c.pool.Candidates {
+		heap.Push(W, r)
+		if W.Len() > k {
+			heap.Pop(W)
+		}
+	}
+	for c.frontier.Len() > 0 {
+		nc := c.frontier.Candidates[0]
+		if W.Len() >= k && nc.Distance > W.Candidates[0].Distance {
+			return
+		}
+		heap.Pop(c.frontier)
+		nbs, ok := c.h.Layers[0][nc.NodeID]
+		if !ok {
+			continue
+		}
+		// Every reached node enters the frontier, not only those closer
+		// than W: a later call with a wider pool may still need them.
+		for _, nb := range nbs.Candidates {
+			if c.visited[nb.NodeID] {
+				continue
+			}
+			if r, ok := c.reach(nb.NodeID); ok {
+				heap.Push(W, r)
+				if W.Len() > k {
+					heap.Pop(W)
+				}
+			}
+		}
+	}
+}
+
+// reach marks id visited and adds it to the frontier, and to the pool if
+// it may be returned, which the second result reports.
+func (c *SearchCursor) reach(id int) (models.Candidate, bool) {
+	c.visited[id] = true
+	r := models.Candidate{NodeID: id, Distance: c.opts.dist(id)}
+	heap.Push(c.frontier, r)
+	if !c.h.admits(c.opts, id) {
+		return r, false
+	}
+	heap.Push(c.pool, r)
+	return r, true
+}
@@ -0,0 +1,87 @@
+package hnsw
+
+import (
+	"container/heap"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// CapDegrees trims every connection list longer than maxDeg, at every
+// layer, to maxDeg connections chosen by SelectNeighborsHeuristic, and
+// returns the number of lists trimmed. Connection lists fill up to 2*M
+// through reverse links, so well-placed nodes become hubs that many
+// searches pass through while others stay sparse; capping them below 2*M
+// evens out the degree distribution. Later inserts may grow lists up to
+// 2*M again.
+func (h *HNSW) CapDegrees(maxDeg int) int {
+	if maxDeg <= 0 {
+		return 0
+	}
+	return h.pruneLists(maxDeg)
+}
+
+// pruneLists trims every connection list longer than limit to the
+// connections chosen by SelectNeighborsHeuristic, as Compact does when it
+// re-links nodes, and returns the number of lists trimmed.
+func (h *HNSW) pruneLists(limit int) int {
+	trimmed := 0
+	for lc, layer := range h.Layers {
+		for _, id := range sortedKeys(layer) {
+			conns := layer[id]
+			if conns.Len() <= limit {
+				continue
+			}
+			candidates := make([]int, conns.Len())
+			for i, c := range conns.Candidates {
+				candidates[i] = c.NodeID
+			}
+			selected := h.SelectNeighborsHeuristic(h.element(id), candidates, limit, lc, false, true)
+			conns.Candidates = conns.Candidates[:0]
+			for _, n := range selected {
+				heap.Push(conns, models.Candidate{
+					NodeID:   n,
+					Distance: h.nodeDistance(id, n),
+				})
+			}
+			trimmed++
+		}
+	}
+	return trimmed
+}
+
+// ConnectionPolicy picks the connections a full connection list keeps when
+// an insert links one more node to it. candidates holds the current
+// connections of node id at layer and the new one, limit + 1 in all, with
+// their distances to id; the policy returns at most limit of them. The
+// returned candidates must come from candidates, since their distances are
+// stored as is.
+type ConnectionPolicy func(h *HNSW, id, layer int, candidates []models.Candidate, limit int) []models.Candidate
+
+// KeepClosest keeps the limit candidates closest to the node, dropping the
+// farthest one. It is the default policy and the rule of the HNSW paper.
+func KeepClosest(h *HNSW, id, layer int, candidates []models.Candidate, limit int) []models.Candidate {
+	res := append([]models.Candidate(nil), candidates...)
+	sortCandidates(res)
+	return res[:min(len(res), limit)]
+}
+
+// KeepDiverse keeps the candidates SelectNeighborsHeuristic selects among
+// them, filling the remaining slots with the closest discarded ones, as
+// CapDegrees does. The dropped connection is therefore the farthest one
+// that is also close to another kept neighbor, rather than the farthest
+// one outright, which keeps long edges between clusters that KeepClosest
+// would evict. Each eviction costs up to limit^2 distance computations.
+func KeepDiverse(h *HNSW, id, layer int, candidates []models.Candidate, limit int) []models.Candidate {
+	byID := make(map[int]models.Candidate, len(candidates))
+	ids := make([]int, len(candidates))
+	for i, c := range candidates {
+		byID[c.NodeID] = c
+		ids[i] = c.NodeID
+	}
+	selected := h.SelectNeighborsHeuristic(h.element(id), ids, limit, layer, false, true)
+	res := make([]models.Candidate, len(selected))
+	for i, n := range selected {
+		res[i] = byID[n]
+	}
+	return res
+}
@@ -0,0 +1,155 @@
+package hnsw
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Delete marks element id as deleted. The node stays in the graph as a
+// routing point, so search quality around it is preserved, but it is no
+// longer returned by searches or Get. Compact removes deleted nodes, and so
+// does InsertLabeled when it reuses the ID of a deleted labeled element. If
+// id is the entry point, another live node of the top layer takes its place.
+func (h *HNSW) Delete(id int) error {
+	if _, ok := h.Get(id); !ok {
+		return fmt.Errorf("element %d: %w", id, ErrNotFound)
+	}
+	h.Deleted[id] = true
+	delete(h.Hidden, id)
+	if _, ok := h.Labels[id]; ok {
+		h.freeIDs = append(h.freeIDs, id)
+	}
+	if id == h.EnterPoint {
+		h.repairEnterPoint()
+	}
+	return nil
+}
+
+// Compact removes all deleted nodes from the graph, re-linking their
+// neighbors, and returns how many were removed. The IDs and labels of the
+// remaining elements are unchanged. It scans every connection once, so it
+// is best run after deletions accumulate rather than after each.
+func (h *HNSW) Compact() int {
+	n := len(h.Deleted)
+	if n > 0 {
+		h.unlinkNodes(h.Deleted)
+	}
+	if h.symmetricRepair {
+		h.RepairSymmetry()
+	}
+	return n
+}
+
+// tombstoneRatio returns the fraction of stored elements that are deleted.
+func (h *HNSW) tombstoneRatio() float64 {
+	if len(h.Elements) == 0 {
+		return 0
+	}
+	return float64(len(h.Deleted)) / float64(len(h.Elements))
+}
+
+// unlinkNodes removes the given nodes from every layer and from Elements.
+// Each remaining node that loses a connection is re-linked by running the
+// neighbor heuristic over its surviving neighbors plus the neighbors of the
+// nodes it lost, so the graph stays navigable around the hole.
+func (h *HNSW) unlinkNodes(ids map[int]bool) {
+	// ids may be h.Deleted itself; copy it before the tombstones are cleared.
+	ids = copySet(ids)
+	for lc, layer := range h.Layers {
+		for id, conns := range layer {
+			if ids[id] || !linksAny(conns.Candidates, ids) {
+				continue
+			}
+			candidates := make([]int, 0, conns.Len())
+			for _, c := range conns.Candidates {
+				if !ids[c.NodeID] {
+					candidates = append(candidates, c.NodeID)
+					continue
+				}
+				for _, n := range layer[c.NodeID].Candidates {
+					if !ids[n.NodeID] && n.NodeID != id {
+						candidates = append(candidates, n.NodeID)
+					}
+				}
+			}
+			selected := h.SelectNeighborsHeuristic(h.element(id), candidates, h.maxConnections, lc, false, true)
+			conns.Candidates = conns.Candidates[:0]
+			for _, n := range selected {
+				heap.Push(conns, models.Candidate{
+					NodeID:   n,
+					Distance: h.nodeDistance(id, n),
+				})
+			}
+		}
+		for id := range ids {
+			delete(layer, id)
+		}
+	}
+	for id := range ids {
+		h.dropElement(id)
+	}
+	h.repairEnterPoint()
+}
+
+// dropElement removes the data of element id, whose node is no longer in
+// the graph.
+func (h *HNSW) dropElement(id int) {
+	delete(h.Elements, id)
+	delete(h.Levels, id)
+	delete(h.Deleted, id)
+	delete(h.Hidden, id)
+	delete(h.norms, id)
+	delete(h.IntVectors, id)
+	delete(h.HalfVectors, id)
+	h.unlabel(id)
+}
+
+// linksAny reports whether any of the connections leads to a node in ids.
+func linksAny(conns []models.Candidate, ids map[int]bool) bool {
+	for _, c := range conns {
+		if ids[c.NodeID] {
+			return true
+		}
+	}
+	return false
+}
+
+// repairEnterPoint maintains the entry point invariant: the entry point is
+// a node of the highest non-empty layer. Empty top layers are dropped, and
+// an entry point outside the top layer, or a deleted one while a live node
+// exists there, is moved to the lowest live ID of the top layer, falling
+// back to the lowest ID.
+func (h *HNSW) repairEnterPoint() {
+	for len(h.Layers) > 0 && len(h.Layers[len(h.Layers)-1]) == 0 {
+		h.Layers = h.Layers[:len(h.Layers)-1]
+	}
+	if len(h.Layers) == 0 {
+		h.EnterPoint = -1
+		return
+	}
+	top := h.Layers[len(h.Layers)-1]
+	if _, ok := top[h.EnterPoint]; ok && !h.Deleted[h.EnterPoint] {
+		return
+	}
+	ids := sortedKeys(top)
+	for _, id := range ids {
+		if !h.Deleted[id] {
+			h.EnterPoint = id
+			return
+		}
+	}
+	if _, ok := top[h.EnterPoint]; !ok {
+		h.EnterPoint = ids[0]
+	}
+}
+
+// copySet returns a copy of the set s.
+func copySet(s map[int]bool) map[int]bool {
+	c := make(map[int]bool, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
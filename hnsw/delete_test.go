@@ -0,0 +1,225 @@
+package hnsw
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// TestDeleteSearch deletes growing shares of an index and checks that
+// searches return K live elements and keep their recall, thanks to the ef
+// inflation by tombstone ratio.
+func TestDeleteSearch(t *testing.T) {
+	const n, d, K, ef = 1000, 16, 10, 10
+	h := buildRandom(t, n, d, 8)
+	r := rand.New(rand.NewSource(9))
+	queries := make([]models.Element, 50)
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, d)}
+	}
+	order := r.Perm(n)
+	deleted := 0
+	var baseline float64
+	for _, share := range []float64{0, 0.25, 0.5, 0.75} {
+		for ; deleted < int(share*n); deleted++ {
+			if err := h.Delete(order[deleted]); err != nil {
+				t.Fatalf("Delete(%d): %v", order[deleted], err)
+			}
+		}
+		if got := h.Stats().TombstoneRatio; math.Abs(got-share) > 1e-9 {
+			t.Errorf("TombstoneRatio = %v, want %v", got, share)
+		}
+		truth := make([][]int, len(queries))
+		for i, q := range queries {
+			res := h.KNNSearchWithEf(q, K, ef)
+			if len(res) != K {
+				t.Fatalf("%v deleted: got %d results, want %d", share, len(res), K)
+			}
+			for _, id := range res {
+				if h.Deleted[id] {
+					t.Fatalf("%v deleted: result %v holds deleted element %d", share, res, id)
+				}
+			}
+			truth[i] = h.BruteForceKNN(q, K)
+		}
+		recall := h.EvaluateRecall(queries, truth, K, ef)
+		if share == 0 {
+			baseline = recall
+		} else if recall < baseline-0.05 {
+			t.Errorf("%v deleted: recall %.3f, %.3f with none deleted", share, recall, baseline)
+		}
+	}
+}
+
+// checkEnterPoint fails unless the entry point is a node of the top layer,
+// and a live one if the top layer has any.
+func checkEnterPoint(t *testing.T, h *HNSW, when string) {
+	t.Helper()
+	if len(h.Layers) == 0 {
+		if h.EnterPoint != -1 {
+			t.Fatalf("%s: entry point %d in an empty index", when, h.EnterPoint)
+		}
+		return
+	}
+	top := h.Layers[len(h.Layers)-1]
+	if _, ok := top[h.EnterPoint]; !ok {
+		t.Fatalf("%s: entry point %d not in top layer %d", when, h.EnterPoint, len(h.Layers)-1)
+	}
+	if h.Deleted[h.EnterPoint] {
+		for id := range top {
+			if !h.Deleted[id] {
+				t.Fatalf("%s: entry point %d deleted while %d is live in the top layer", when, h.EnterPoint, id)
+			}
+		}
+	}
+}
+
+// TestEnterPointTopLayer deletes the entry point over and over, with
+// inserts, compactions and a save and load in between, and checks that the
+// entry point stays in the top layer.
+func TestEnterPointTopLayer(t *testing.T) {
+	const d = 4
+	h := buildRandom(t, 300, d, 28)
+	r := rand.New(rand.NewSource(29))
+	next := 300
+	for round := 0; round < 200; round++ {
+		// Once the top layer holds only tombstones, delete elsewhere.
+		victim := h.EnterPoint
+		for _, ok := h.Get(victim); !ok; _, ok = h.Get(victim) {
+			victim = r.Intn(next)
+		}
+		if err := h.Delete(victim); err != nil {
+			t.Fatalf("round %d: Delete(%d): %v", round, victim, err)
+		}
+		checkEnterPoint(t, h, "after Delete")
+		if round%3 == 0 {
+			if err := h.Insert(models.Element{ID: next, Embeddings: randomVector(r, d)}); err != nil {
+				t.Fatal(err)
+			}
+			next++
+			checkEnterPoint(t, h, "after Insert")
+		}
+		if round%50 == 49 {
+			h.Compact()
+			checkEnterPoint(t, h, "after Compact")
+		}
+	}
+	var buf bytes.Buffer
+	if err := h.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	res, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	checkEnterPoint(t, res, "after Load")
+	for _, id := range sortedKeys(h.Elements) {
+		if !h.Deleted[id] {
+			if err := h.Delete(id); err != nil {
+				t.Fatal(err)
+			}
+			checkEnterPoint(t, h, "deleting all")
+		}
+	}
+	h.Compact()
+	checkEnterPoint(t, h, "after compacting all")
+	if err := h.Insert(models.Element{ID: next, Embeddings: randomVector(r, d)}); err != nil {
+		t.Fatal(err)
+	}
+	checkEnterPoint(t, h, "after reinserting")
+	if h.EnterPoint != next {
+		t.Errorf("entry point %d, want the only element %d", h.EnterPoint, next)
+	}
+}
+
+// TestSearchDeletedNeighbors deletes every neighbor of the entry point, at
+// every layer, so that upper-layer searches find no live candidate, and
+// checks that searches still return live results.
+func TestSearchDeletedNeighbors(t *testing.T) {
+	const d, K = 4, 10
+	h := buildRandom(t, 300, d, 30)
+	ep := h.EnterPoint
+	for lc := range h.Layers {
+		for _, id := range candidateIDs(h.Neighbors(ep, lc)) {
+			if _, ok := h.Get(id); ok {
+				if err := h.Delete(id); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if h.EnterPoint != ep {
+		t.Fatalf("entry point moved from %d to %d", ep, h.EnterPoint)
+	}
+	q := models.Element{Embeddings: randomVector(rand.New(rand.NewSource(31)), d)}
+	top := len(h.Layers) - 1
+	if res := h.SearchLayerCandidates(q, ep, 1, top); len(res) == 0 {
+		t.Errorf("SearchLayerCandidates at layer %d returned nothing", top)
+	}
+	res := h.KNNSearchWithEf(q, K, 50)
+	if len(res) != K {
+		t.Fatalf("got %d results, want %d", len(res), K)
+	}
+	for _, id := range res {
+		if h.Deleted[id] {
+			t.Errorf("result %d is deleted", id)
+		}
+	}
+	// With the entry point deleted too, it is moved and searches go on.
+	if err := h.Delete(ep); err != nil {
+		t.Fatal(err)
+	}
+	if res := h.KNNSearchWithEf(q, K, 50); len(res) != K {
+		t.Errorf("after deleting the entry point: got %d results, want %d", len(res), K)
+	}
+}
+
+func TestUndelete(t *testing.T) {
+	const n, d, K = 300, 4, 10
+	h := buildRandom(t, n, d, 34)
+	r := rand.New(rand.NewSource(35))
+	e, _ := h.Get(7)
+	q := models.Element{Embeddings: e.Embeddings}
+	if got := h.KNNSearch(q, 1); !slices.Equal(got, []int{7}) {
+		t.Fatalf("KNNSearch = %v, want [7]", got)
+	}
+	want := h.KNNSearchWithEf(q, K, 50)
+	hidden := append([]int{7}, r.Perm(n)[:50]...)
+	for _, id := range hidden {
+		if !h.Hidden[id] {
+			if err := h.SoftDelete(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, id := range h.KNNSearchWithEf(q, K, 50) {
+		if h.Hidden[id] {
+			t.Fatalf("hidden element %d returned", id)
+		}
+	}
+	if _, ok := h.Get(7); !ok {
+		t.Error("Get(7) failed on a hidden element")
+	}
+	if h.Len() != n-len(h.Hidden) {
+		t.Errorf("Len = %d with %d hidden, want %d", h.Len(), len(h.Hidden), n-len(h.Hidden))
+	}
+	for id := range h.Hidden {
+		if err := h.Undelete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if h.Len() != n {
+		t.Errorf("Len = %d after Undelete, want %d", h.Len(), n)
+	}
+	if got := h.KNNSearchWithEf(q, K, 50); !slices.Equal(got, want) {
+		t.Errorf("KNNSearchWithEf after Undelete = %v, want %v", got, want)
+	}
+	if err := h.Undelete(7); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Undelete of a visible element: %v, want ErrNotFound", err)
+	}
+}
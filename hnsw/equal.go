@@ -0,0 +1,145 @@
+package hnsw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Diff compares h with other and describes the first difference found, or
+// returns nil if the two indexes are structurally equivalent: the same
+// metric, dimension and entry point, the same elements with the same
+// vectors, messages, groups, payloads and creation times, the same levels,
+// tombstones, hidden elements and labels, and the same connections on
+// every layer.
+//
+// Connection lists are compared as sorted sets of neighbor IDs, so two
+// heaps holding the same neighbors in a different slice order are equal.
+// Connection distances are not compared; they follow from the vectors and
+// the metric. Vectors are compared as Get returns them, so a Float16 index
+// equals a float64 index holding the widened vectors. Build and search
+// parameters such as EfConstruction and DefaultEf are not compared.
+//
+// Elements and nodes are visited in ascending ID order, so the reported
+// difference is the same on every call.
+func (h *HNSW) Diff(other *HNSW) error {
+	if m, o := h.metric(), other.metric(); m != o {
+		return fmt.Errorf("hnsw: metric %s != %s", m, o)
+	}
+	if h.Dim != other.Dim {
+		return fmt.Errorf("hnsw: dimension %d != %d", h.Dim, other.Dim)
+	}
+	if h.EnterPoint != other.EnterPoint {
+		return fmt.Errorf("hnsw: entry point %d != %d", h.EnterPoint, other.EnterPoint)
+	}
+	if err := diffIDs("elements", sortedKeys(h.Elements), sortedKeys(other.Elements)); err != nil {
+		return err
+	}
+	for _, id := range sortedKeys(h.Elements) {
+		if err := h.diffElement(other, id); err != nil {
+			return fmt.Errorf("hnsw: element %d: %w", id, err)
+		}
+	}
+	if err := diffIDs("deleted", sortedKeys(h.Deleted), sortedKeys(other.Deleted)); err != nil {
+		return err
+	}
+	if err := diffIDs("hidden", sortedKeys(h.Hidden), sortedKeys(other.Hidden)); err != nil {
+		return err
+	}
+	if len(h.Layers) != len(other.Layers) {
+		return fmt.Errorf("hnsw: %d layers != %d", len(h.Layers), len(other.Layers))
+	}
+	for lc, layer := range h.Layers {
+		olayer := other.Layers[lc]
+		if err := diffIDs(fmt.Sprintf("layer %d nodes", lc), sortedKeys(layer), sortedKeys(olayer)); err != nil {
+			return err
+		}
+		for _, id := range sortedKeys(layer) {
+			a, b := neighborIDs(layer[id].Candidates), neighborIDs(olayer[id].Candidates)
+			if !slices.Equal(a, b) {
+				return fmt.Errorf("hnsw: layer %d node %d: neighbors %v != %v", lc, id, a, b)
+			}
+		}
+	}
+	return nil
+}
+
+// Equal reports whether h and other are structurally equivalent, as
+// defined by Diff.
+func (h *HNSW) Equal(other *HNSW) bool {
+	return h.Diff(other) == nil
+}
+
+// metric returns the index metric, L2 when not set explicitly.
+func (h *HNSW) metric() Metric {
+	if h.Metric == "" {
+		return L2
+	}
+	return h.Metric
+}
+
+// diffElement compares the element id, present in both h and other.
+func (h *HNSW) diffElement(other *HNSW, id int) error {
+	a, b := h.Elements[id], other.Elements[id]
+	switch {
+	case a.Msg != b.Msg:
+		return fmt.Errorf("msg %q != %q", a.Msg, b.Msg)
+	case a.GroupID != b.GroupID:
+		return fmt.Errorf("group %d != %d", a.GroupID, b.GroupID)
+	case !bytes.Equal(a.Payload, b.Payload):
+		return errors.New("payloads differ")
+	case !a.CreatedAt.Equal(b.CreatedAt):
+		return fmt.Errorf("created %v != %v", a.CreatedAt, b.CreatedAt)
+	case h.Levels[id] != other.Levels[id]:
+		return fmt.Errorf("level %d != %d", h.Levels[id], other.Levels[id])
+	}
+	if h.Metric.isInt() {
+		if !slices.Equal(h.IntVectors[id], other.IntVectors[id]) {
+			return errors.New("vectors differ")
+		}
+	} else if !slices.Equal(h.vector(id), other.vector(id)) {
+		return errors.New("vectors differ")
+	}
+	la, oka := h.Labels[id]
+	lb, okb := other.Labels[id]
+	if oka != okb || la != lb {
+		return fmt.Errorf("label %s != %s", labelString(la, oka), labelString(lb, okb))
+	}
+	return nil
+}
+
+// labelString formats an optional label for Diff.
+func labelString(label int, ok bool) string {
+	if !ok {
+		return "none"
+	}
+	return fmt.Sprint(label)
+}
+
+// diffIDs compares two sorted ID lists and reports the first ID present in
+// only one of them.
+func diffIDs(what string, a, b []int) error {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		switch {
+		case i == len(b) || i < len(a) && a[i] < b[i]:
+			return fmt.Errorf("hnsw: %s: %d missing from other", what, a[i])
+		case i == len(a) || a[i] > b[i]:
+			return fmt.Errorf("hnsw: %s: %d only in other", what, b[i])
+		}
+	}
+	return nil
+}
+
+// neighborIDs returns the sorted IDs of candidates.
+func neighborIDs(candidates []models.Candidate) []int {
+	ids := make([]int, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.NodeID
+	}
+	sort.Ints(ids)
+	return ids
+}
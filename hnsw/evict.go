@@ -0,0 +1,80 @@
+package hnsw
+
+import "github.com/lblclass/hnswgo/models"
+
+// EvictionPolicy picks the live element to evict when an index created with
+// WithMaxElements is full. It is called before incoming is inserted.
+type EvictionPolicy func(h *HNSW, incoming models.Element) int
+
+// compactFraction is the share of MaxElements that evicted tombstones may
+// reach before an insert compacts them away.
+const compactFraction = 10
+
+// makeRoom evicts elements until q fits under the element limit. Elements
+// hidden by SoftDelete count towards the limit and may be evicted. Evicted
+// elements are removed through Delete and physically reclaimed by Compact
+// once their tombstones reach a tenth of the limit.
+func (h *HNSW) makeRoom(q models.Element) {
+	if _, ok := h.Get(q.ID); ok {
+		return
+	}
+	h.insertOrder = append(h.insertOrder, q.ID)
+	for len(h.Elements)-len(h.Deleted) >= h.maxElements {
+		if h.Delete(h.evict(h, q)) != nil {
+			break
+		}
+	}
+	if len(h.Deleted) >= max(1, h.maxElements/compactFraction) {
+		h.Compact()
+	}
+}
+
+// EvictOldest evicts the live element inserted longest ago. Insertion order
+// is kept in memory only, so after loading an index the loaded elements are
+// evicted in ascending ID order, before newer inserts.
+func EvictOldest(h *HNSW, incoming models.Element) int {
+	if len(h.insertOrder) < h.Len() {
+		h.seedInsertOrder()
+	}
+	for len(h.insertOrder) > 0 {
+		id := h.insertOrder[0]
+		h.insertOrder = h.insertOrder[1:]
+		if _, ok := h.Get(id); ok && id != incoming.ID {
+			return id
+		}
+	}
+	return -1
+}
+
+// seedInsertOrder puts live elements missing from the insertion order, such
+// as loaded ones, at its front in ascending ID order.
+func (h *HNSW) seedInsertOrder() {
+	known := make(map[int]bool, len(h.insertOrder))
+	for _, id := range h.insertOrder {
+		known[id] = true
+	}
+	var missing []int
+	for _, id := range sortedKeys(h.Elements) {
+		if !known[id] && !h.Deleted[id] {
+			missing = append(missing, id)
+		}
+	}
+	h.insertOrder = append(missing, h.insertOrder...)
+}
+
+// EvictFarthestFromCentroid evicts the live element farthest from the mean
+// of all live vectors, keeping the index focused on the dense region. It
+// costs O(N*dim) per eviction.
+func EvictFarthestFromCentroid(h *HNSW, incoming models.Element) int {
+	dist := h.centroidDistance(h.Centroid())
+	victim, farthest := -1, -1.0
+	for _, id := range sortedKeys(h.Elements) {
+		if h.Deleted[id] || id == incoming.ID {
+			continue
+		}
+		if d := dist(id); d > farthest {
+			victim, farthest = id, d
+		}
+	}
+	return victim
+}
@@ -0,0 +1,105 @@
+package hnsw
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// stored returns the number of elements of h not deleted, hidden included.
+func stored(h *HNSW) int {
+	return len(h.Elements) - len(h.Deleted)
+}
+
+func TestMaxElements(t *testing.T) {
+	const limit = 50
+	r := rand.New(rand.NewSource(15))
+	h := NewHNSW(20, 4, 3, 0.72, WithMaxElements(limit, nil))
+	for i := 0; i < 200; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, 4)}); err != nil {
+			t.Fatal(err)
+		}
+		if got := stored(h); got > limit {
+			t.Fatalf("after %d inserts: %d elements, limit %d", i+1, got, limit)
+		}
+	}
+	// EvictOldest keeps the newest elements.
+	for id := 150; id < 200; id++ {
+		if _, ok := h.Get(id); !ok {
+			t.Errorf("element %d evicted, want only elements below 150 evicted", id)
+		}
+	}
+}
+
+func TestMaxElementsHidden(t *testing.T) {
+	const limit = 20
+	r := rand.New(rand.NewSource(16))
+	h := NewHNSW(20, 4, 3, 0.72, WithMaxElements(limit, nil))
+	for i := 0; i < limit; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, 4)}); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := h.SoftDelete(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := limit; i < 3*limit; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, 4)}); err != nil {
+			t.Fatal(err)
+		}
+		if got := stored(h); got > limit {
+			t.Fatalf("after inserting %d: %d elements with %d hidden, limit %d", i, got, len(h.Hidden), limit)
+		}
+	}
+	if len(h.Hidden) != 0 {
+		t.Errorf("%d hidden elements left, want all evicted as the oldest", len(h.Hidden))
+	}
+	if h.Len() != limit {
+		t.Errorf("Len = %d, want %d", h.Len(), limit)
+	}
+}
+
+// TestEdgeSymmetryAfterEvictions builds with M = 2 under WithMaxElements,
+// so that both full connection lists and element evictions drop edges, and
+// checks the symmetry report before and after RepairSymmetry.
+func TestEdgeSymmetryAfterEvictions(t *testing.T) {
+	r := rand.New(rand.NewSource(36))
+	h := NewHNSW(20, 2, 3, 0.72, WithSeed(36), WithMaxElements(100, nil))
+	for i := 0; i < 400; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, 4)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edges := 0
+	for _, layer := range h.Layers {
+		for _, conns := range layer {
+			edges += conns.Len()
+		}
+	}
+	symmetric, asymmetric := h.EdgeSymmetryReport()
+	if symmetric+asymmetric != edges {
+		t.Fatalf("report counts %d+%d edges, want %d", symmetric, asymmetric, edges)
+	}
+	if asymmetric == 0 {
+		t.Fatal("no asymmetric edges after forced evictions")
+	}
+	added, removed := h.RepairSymmetry()
+	t.Logf("%d symmetric, %d asymmetric; repair added %d, removed %d", symmetric, asymmetric, added, removed)
+	if added+removed == 0 {
+		t.Error("RepairSymmetry changed nothing")
+	}
+	// The only one-way edges left are those kept as a node's sole
+	// connection.
+	for lc, layer := range h.Layers {
+		for id, conns := range layer {
+			for _, c := range conns.Candidates {
+				if !hasConnection(layer[c.NodeID], id) && conns.Len() != 1 {
+					t.Errorf("layer %d: one-way edge %d->%d out of %d connections", lc, id, c.NodeID, conns.Len())
+				}
+			}
+		}
+	}
+}
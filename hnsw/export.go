@@ -0,0 +1,98 @@
+package hnsw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// columnarExport is the document written by ExportColumnar.
+type columnarExport struct {
+	Schema  columnarSchema         `json:"schema"`
+	Columns map[string]interface{} `json:"columns"`
+}
+
+// columnarSchema describes the index the columns were exported from.
+type columnarSchema struct {
+	Rows       int    `json:"rows"`
+	Dim        int    `json:"dim"`
+	Metric     Metric `json:"metric"`
+	Layers     int    `json:"layers"`
+	EnterPoint int    `json:"enter_point"`
+}
+
+// ExportColumnar writes the vectors and adjacency of the index to w as a
+// column-oriented JSON document, one row per element in ascending ID order,
+// for inspection with analytics tooling. It is an export only; there is no
+// matching loader.
+//
+// The document has a "schema" object (rows, dim, metric, layers,
+// enter_point) and a "columns" object of equal-length arrays:
+//
+//	id           int64
+//	msg          string
+//	group_id     int64
+//	payload      binary             base64 string, null without payload
+//	deleted      bool
+//	level        int64              top layer of the element
+//	vector       fixed_size_list<float64>[dim]
+//	neighbors_L  list<int64>        connections at layer L, null above level
+//
+// In Python, pyarrow.Table.from_pydict(doc["columns"]) or
+// pandas.DataFrame(doc["columns"]) loads the columns directly. Arrow IPC
+// and Parquet writers are left out to keep the module free of dependencies.
+func (h *HNSW) ExportColumnar(w io.Writer) error {
+	ids := sortedKeys(h.Elements)
+	msgs := make([]string, len(ids))
+	groups := make([]int, len(ids))
+	payloads := make([][]byte, len(ids))
+	deleted := make([]bool, len(ids))
+	levels := make([]int, len(ids))
+	vectors := make([][]float64, len(ids))
+	neighbors := make([][][]int, len(h.Layers))
+	for lc := range neighbors {
+		neighbors[lc] = make([][]int, len(ids))
+	}
+
+	dim := 0
+	for i, id := range ids {
+		e := h.element(id)
+		msgs[i] = e.Msg
+		groups[i] = e.GroupID
+		payloads[i] = e.Payload
+		deleted[i] = h.Deleted[id]
+		levels[i] = h.LevelOf(id)
+		vectors[i] = e.Embeddings
+		if dim == 0 {
+			dim = len(e.Embeddings)
+		}
+		for lc, layer := range h.Layers {
+			if conns, ok := layer[id]; ok {
+				neighbors[lc][i] = conns.ExtractHeapData()
+			}
+		}
+	}
+
+	columns := map[string]interface{}{
+		"id":       ids,
+		"msg":      msgs,
+		"group_id": groups,
+		"payload":  payloads,
+		"deleted":  deleted,
+		"level":    levels,
+		"vector":   vectors,
+	}
+	for lc := range neighbors {
+		columns[fmt.Sprintf("neighbors_%d", lc)] = neighbors[lc]
+	}
+	return json.NewEncoder(w).Encode(columnarExport{
+		Schema: columnarSchema{
+			Rows:       len(ids),
+			Dim:        dim,
+			Metric:     h.Metric,
+			Layers:     len(h.Layers),
+			EnterPoint: h.EnterPoint,
+		},
+		Columns: columns,
+	})
+}
@@ -0,0 +1,36 @@
+package hnsw
+
+// Float32 interop. The index stores and searches float64 vectors, while
+// most embedding models and libraries produce float32. ToFloat64 and
+// ToFloat32 are the conversions to use at that boundary, for vectors and
+// queries alike.
+
+// ToFloat64 widens v to float64. The conversion is exact, infinities and
+// NaNs included, so ToFloat32(ToFloat64(v)) returns v bit for bit, NaN
+// payloads aside. A nil slice gives nil and an empty one an empty one.
+func ToFloat64(v []float32) []float64 {
+	if v == nil {
+		return nil
+	}
+	res := make([]float64, len(v))
+	for i, x := range v {
+		res[i] = float64(x)
+	}
+	return res
+}
+
+// ToFloat32 narrows v to float32, rounding each value to the nearest
+// float32 with ties to even as IEEE 754 specifies. Values that round
+// beyond math.MaxFloat32 become infinities, values that round below the
+// smallest float32 subnormal become zeros of the same sign, and NaNs stay
+// NaNs. A nil slice gives nil and an empty one an empty one.
+func ToFloat32(v []float64) []float32 {
+	if v == nil {
+		return nil
+	}
+	res := make([]float32, len(v))
+	for i, x := range v {
+		res[i] = float32(x)
+	}
+	return res
+}
@@ -0,0 +1,63 @@
+package hnsw
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// TestFloat32RoundTrip checks that widening and narrowing back returns
+// every float32 unchanged, for special values and random bit patterns.
+func TestFloat32RoundTrip(t *testing.T) {
+	v := []float32{
+		0, float32(math.Copysign(0, -1)), 1, -1,
+		float32(math.Inf(1)), float32(math.Inf(-1)), float32(math.NaN()),
+		math.MaxFloat32, -math.MaxFloat32, math.SmallestNonzeroFloat32, 0x1p-126,
+	}
+	r := rand.New(rand.NewSource(22))
+	for i := 0; i < 100000; i++ {
+		v = append(v, math.Float32frombits(r.Uint32()))
+	}
+	got := ToFloat32(ToFloat64(v))
+	if len(got) != len(v) {
+		t.Fatalf("round trip of %d values gave %d", len(v), len(got))
+	}
+	for i, x := range v {
+		if math.IsNaN(float64(x)) {
+			if !math.IsNaN(float64(got[i])) {
+				t.Errorf("round trip of NaN gave %v", got[i])
+			}
+			continue
+		}
+		if math.Float32bits(got[i]) != math.Float32bits(x) {
+			t.Errorf("round trip of %v (%#08x) gave %v (%#08x)", x, math.Float32bits(x), got[i], math.Float32bits(got[i]))
+		}
+	}
+}
+
+func TestToFloat32Special(t *testing.T) {
+	for _, tc := range []struct {
+		f    float64
+		want float32
+	}{
+		{1e39, float32(math.Inf(1))},
+		{-1e39, float32(math.Inf(-1))},
+		{1e-46, 0},
+		{-1e-46, float32(math.Copysign(0, -1))},
+		{0x1.8p-149, 0x1p-148}, // Midpoint, ties to even
+		{1 + 0x1p-24, 1},       // Midpoint, ties to even
+	} {
+		if got := ToFloat32([]float64{tc.f})[0]; math.Float32bits(got) != math.Float32bits(tc.want) {
+			t.Errorf("ToFloat32(%v) = %v, want %v", tc.f, got, tc.want)
+		}
+	}
+	if got := ToFloat32([]float64{math.NaN()})[0]; !math.IsNaN(float64(got)) {
+		t.Errorf("ToFloat32(NaN) = %v, want NaN", got)
+	}
+	if ToFloat64(nil) != nil || ToFloat32(nil) != nil {
+		t.Error("nil slice did not stay nil")
+	}
+	if got := ToFloat64([]float32{}); got == nil || len(got) != 0 {
+		t.Errorf("ToFloat64(empty) = %#v, want an empty slice", got)
+	}
+}
@@ -0,0 +1,72 @@
+package hnsw
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// Gzip-compressed stores.
+//
+// Embeddings compress poorly because their low-order mantissa bits are close
+// to random; embeddings produced as float32 and widened to float64 have zero
+// low bits and compress better. JSON output is larger than gob both before
+// and after compression. Prefer the gob variants unless other tools need to
+// read the files.
+
+// SaveGobGzip writes the index to path in the gob format of Save, gzipped.
+func (h *HNSW) SaveGobGzip(path string) error {
+	return writeGzip(path, h.Save)
+}
+
+// LoadGobGzip reads an index written by SaveGobGzip.
+func LoadGobGzip(path string) (*HNSW, error) {
+	return readGzip(path, Load)
+}
+
+// SaveJSONGzip writes the index to path as gzipped JSON.
+func (h *HNSW) SaveJSONGzip(path string) error {
+	return writeGzip(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(h)
+	})
+}
+
+// LoadJSONGzip reads an index written by SaveJSONGzip.
+func LoadJSONGzip(path string) (*HNSW, error) {
+	return readGzip(path, func(r io.Reader) (*HNSW, error) {
+		var res = HNSW{}
+		if err := json.NewDecoder(r).Decode(&res); err != nil {
+			return nil, err
+		}
+		res.restore()
+		return &res, nil
+	})
+}
+
+// writeGzip atomically replaces path with a gzip stream filled by write.
+func writeGzip(path string, write func(io.Writer) error) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		zw := gzip.NewWriter(w)
+		if err := write(zw); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// readGzip opens path and passes read the decompressed stream.
+func readGzip(path string, read func(io.Reader) (*HNSW, error)) (*HNSW, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return read(zr)
+}
@@ -0,0 +1,201 @@
+package hnsw
+
+import (
+	"math"
+	"sync"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Half-precision storage. An index created WithFloat16 keeps each vector in
+// HalfVectors as IEEE-754 binary16 values, a quarter of the float64 size,
+// and stores its Element without Embeddings. Distances widen the halves to
+// float64 on the fly; queries keep full precision. Get and the other
+// accessors return the widened vector.
+
+// floatToHalf converts f to binary16 through float32, rounding to nearest
+// with ties to even at each step. Values beyond the half range become
+// infinities and values below it subnormals or zero.
+func floatToHalf(f float64) uint16 {
+	bits := math.Float32bits(float32(f))
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23&0xff) - 127 + 15
+	mant := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff >= 0x7f800000: // Inf or NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp >= 0x1f: // Overflow
+		return sign | 0x7c00
+	case exp <= 0: // Subnormal or zero
+		if exp < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rem > mid || rem == mid && half&1 == 1 {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(exp)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || rem == 0x1000 && half&1 == 1 {
+		half++ // May carry into the exponent, up to infinity
+	}
+	return sign | uint16(half)
+}
+
+// halfToFloat converts the binary16 value u to float64 exactly.
+func halfToFloat(u uint16) float64 {
+	sign := uint32(u&0x8000) << 16
+	exp := int(u>>10) & 0x1f
+	mant := uint32(u & 0x3ff)
+	switch {
+	case exp == 0x1f: // Inf or NaN
+		return float64(math.Float32frombits(sign | 0x7f800000 | mant<<13))
+	case exp == 0:
+		if mant == 0 {
+			return float64(math.Float32frombits(sign))
+		}
+		// Subnormal: normalize the mantissa.
+		exp = 1
+		for mant&0x400 == 0 {
+			mant <<= 1
+			exp--
+		}
+		mant &= 0x3ff
+	}
+	return float64(math.Float32frombits(sign | uint32(exp+127-15)<<23 | mant<<13))
+}
+
+// halfTable maps every binary16 value to float64. Distances read it
+// instead of decoding each value with halfToFloat.
+var (
+	halfTable     *[1 << 16]float64
+	halfTableOnce sync.Once
+)
+
+// widen returns halfTable, building it on first use.
+func widen() *[1 << 16]float64 {
+	halfTableOnce.Do(func() {
+		halfTable = new([1 << 16]float64)
+		for u := range halfTable {
+			halfTable[u] = halfToFloat(uint16(u))
+		}
+	})
+	return halfTable
+}
+
+// toHalf converts v to half precision.
+func toHalf(v []float64) []uint16 {
+	res := make([]uint16, len(v))
+	for i, x := range v {
+		res[i] = floatToHalf(x)
+	}
+	return res
+}
+
+// fromHalf widens the half-precision vector v to float64.
+func fromHalf(v []uint16) []float64 {
+	res := make([]float64, len(v))
+	for i, x := range v {
+		res[i] = halfToFloat(x)
+	}
+	return res
+}
+
+// halfSquaredL2 returns the squared Euclidean distance between a and the
+// half-precision vector b.
+func halfSquaredL2(a []float64, b []uint16) float64 {
+	t := widen()
+	sum := 0.0
+	for i := range a {
+		diff := a[i] - t[b[i]]
+		sum += diff * diff
+	}
+	return sum
+}
+
+// halfDot returns the dot product of a and the half-precision vector b.
+func halfDot(a []float64, b []uint16) float64 {
+	t := widen()
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * t[b[i]]
+	}
+	return sum
+}
+
+// halfPairSquaredL2 returns the squared Euclidean distance between two
+// half-precision vectors.
+func halfPairSquaredL2(a, b []uint16) float64 {
+	t := widen()
+	sum := 0.0
+	for i := range a {
+		diff := t[a[i]] - t[b[i]]
+		sum += diff * diff
+	}
+	return sum
+}
+
+// halfPairDot returns the dot product of two half-precision vectors.
+func halfPairDot(a, b []uint16) float64 {
+	t := widen()
+	sum := 0.0
+	for i := range a {
+		sum += t[a[i]] * t[b[i]]
+	}
+	return sum
+}
+
+// halfStored reports whether distances read vectors from HalfVectors.
+func (h *HNSW) halfStored() bool {
+	return h.Float16 && h.vectorStore == nil && !h.Metric.isInt()
+}
+
+// halfQueryDistance is queryDistance for an index created WithFloat16.
+func (h *HNSW) halfQueryDistance(q models.Element) func(id int) float64 {
+	switch h.Metric {
+	case Cosine, Angular:
+		qn := norm(q.Embeddings)
+		return func(id int) float64 {
+			nb := h.nodeNorm(id)
+			if qn == 0 || nb == 0 {
+				return h.angle(1)
+			}
+			return h.angle(1 - halfDot(q.Embeddings, h.HalfVectors[id])/(qn*nb))
+		}
+	case InnerProduct:
+		return func(id int) float64 {
+			return -halfDot(q.Embeddings, h.HalfVectors[id])
+		}
+	default:
+		return func(id int) float64 {
+			return math.Sqrt(halfSquaredL2(q.Embeddings, h.HalfVectors[id]))
+		}
+	}
+}
+
+// halfNodeDistance is nodeDistance for an index created WithFloat16.
+func (h *HNSW) halfNodeDistance(a, b int) float64 {
+	va, vb := h.HalfVectors[a], h.HalfVectors[b]
+	switch h.Metric {
+	case Cosine, Angular:
+		na, nb := h.nodeNorm(a), h.nodeNorm(b)
+		if na == 0 || nb == 0 {
+			return h.angle(1)
+		}
+		return h.angle(1 - halfPairDot(va, vb)/(na*nb))
+	case InnerProduct:
+		return -halfPairDot(va, vb)
+	default:
+		return math.Sqrt(halfPairSquaredL2(va, vb))
+	}
+}
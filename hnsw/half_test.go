@@ -0,0 +1,61 @@
+package hnsw
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloatToHalfSpecial(t *testing.T) {
+	for _, tc := range []struct {
+		f    float64
+		want uint16
+	}{
+		{math.Inf(1), 0x7c00},
+		{math.Inf(-1), 0xfc00},
+		{math.Copysign(0, -1), 0x8000},
+		{65504, 0x7bff}, // Largest finite half
+		{65519, 0x7bff}, // Below the midpoint to 65536
+		{65520, 0x7c00}, // Midpoint, ties to even: overflows
+		{1e6, 0x7c00},   // Far past the range
+		{-1e6, 0xfc00},
+		{0x1p-14, 0x0400},              // Smallest normal
+		{0x3ffp-24, 0x03ff},            // Largest subnormal
+		{0x1p-24, 0x0001},              // Smallest subnormal
+		{0x1p-25, 0x0000},              // Midpoint to zero, ties to even
+		{0x1.8p-25, 0x0001},            // Above the midpoint
+		{-0x1p-26, 0x8000},             // Underflows to a signed zero
+		{0x1.ffcp-1 + 0x1p-12, 0x3c00}, // Midpoint, ties to even: carries into the exponent
+	} {
+		if got := floatToHalf(tc.f); got != tc.want {
+			t.Errorf("floatToHalf(%v) = %#04x, want %#04x", tc.f, got, tc.want)
+		}
+	}
+	if got := floatToHalf(math.NaN()); got&0x7c00 != 0x7c00 || got&0x3ff == 0 {
+		t.Errorf("floatToHalf(NaN) = %#04x, want a NaN", got)
+	}
+}
+
+// TestHalfRoundTrip checks every binary16 value: widening is exact, so
+// converting back gives the same bits, and the lookup table agrees.
+func TestHalfRoundTrip(t *testing.T) {
+	table := widen()
+	for i := 0; i < 1<<16; i++ {
+		u := uint16(i)
+		f := halfToFloat(u)
+		if math.Float64bits(table[u]) != math.Float64bits(f) {
+			t.Fatalf("halfTable[%#04x] = %v, want %v", u, table[u], f)
+		}
+		if math.IsNaN(f) {
+			if u&0x7c00 != 0x7c00 || u&0x3ff == 0 {
+				t.Fatalf("halfToFloat(%#04x) = NaN", u)
+			}
+			continue
+		}
+		if got := floatToHalf(f); got != u {
+			t.Fatalf("floatToHalf(halfToFloat(%#04x) = %v) = %#04x", u, f, got)
+		}
+	}
+	if f := halfToFloat(0x7e00); !math.IsNaN(f) {
+		t.Errorf("halfToFloat(0x7e00) = %v, want NaN", f)
+	}
+}
@@ -0,0 +1,154 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInconsistent is returned when an index fails its consistency checks.
+var ErrInconsistent = errors.New("hnsw: index is inconsistent")
+
+// maxReportedViolations bounds how many violations HealthCheck describes.
+const maxReportedViolations = 5
+
+// HealthCheck verifies the internal consistency of the index: every layer
+// is non-empty, every connection heap satisfies the heap property, respects
+// the connection limit and only references other elements of the same
+// layer, every element is in the layers its level says, and the entry point
+// is an element of the top layer. Edges are not required to be symmetric,
+// since eviction in addConnection legitimately drops one direction.
+//
+// The returned error wraps ErrInconsistent and describes the first few
+// violations. Checks run in ID order, so the report is stable.
+func (h *HNSW) HealthCheck() error {
+	var violations []string
+	total := 0
+	report := func(format string, args ...interface{}) {
+		total++
+		if len(violations) < maxReportedViolations {
+			violations = append(violations, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if len(h.Layers) == 0 {
+		if h.EnterPoint != -1 {
+			report("entry point %d set on a graph without layers", h.EnterPoint)
+		}
+	} else if _, ok := h.Layers[len(h.Layers)-1][h.EnterPoint]; !ok {
+		report("entry point %d is not in top layer %d", h.EnterPoint, len(h.Layers)-1)
+	}
+
+	for lc, layer := range h.Layers {
+		if len(layer) == 0 {
+			report("layer %d is empty", lc)
+		}
+		for _, id := range sortedKeys(layer) {
+			conns := layer[id]
+			if _, ok := h.Elements[id]; !ok {
+				report("node %d in layer %d has no element", id, lc)
+			}
+			if level, ok := h.Levels[id]; ok && level < lc {
+				report("node %d in layer %d has level %d", id, lc, level)
+			}
+			if conns.Len() > h.maxConnections {
+				report("node %d in layer %d has %d connections, limit %d", id, lc, conns.Len(), h.maxConnections)
+			}
+			for i := 1; i < conns.Len(); i++ {
+				if conns.Less(i, (i-1)/2) {
+					report("connections of node %d in layer %d violate the heap property at %d", id, lc, i)
+					break
+				}
+			}
+			seen := make(map[int]bool, conns.Len())
+			for _, c := range conns.Candidates {
+				switch {
+				case c.NodeID == id:
+					report("node %d in layer %d is connected to itself", id, lc)
+				case seen[c.NodeID]:
+					report("node %d in layer %d is connected to %d twice", id, lc, c.NodeID)
+				case layer[c.NodeID] == nil:
+					report("node %d in layer %d is connected to %d, which is not in the layer", id, lc, c.NodeID)
+				}
+				seen[c.NodeID] = true
+			}
+		}
+	}
+
+	for _, id := range sortedKeys(h.Deleted) {
+		if _, ok := h.Elements[id]; !ok {
+			report("deleted node %d has no element", id)
+		}
+	}
+	for _, id := range sortedKeys(h.Hidden) {
+		if _, ok := h.Elements[id]; !ok || h.Deleted[id] {
+			report("hidden node %d is not a live element", id)
+		}
+	}
+
+	for _, id := range sortedKeys(h.Elements) {
+		level, ok := h.Levels[id]
+		if !ok {
+			level = 0
+		}
+		for lc := 0; lc <= level && lc < len(h.Layers); lc++ {
+			if _, ok := h.Layers[lc][id]; !ok {
+				report("element %d with level %d is missing from layer %d", id, level, lc)
+				break
+			}
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d violations: %s", ErrInconsistent, total, strings.Join(violations, "; "))
+}
+
+// ReachableCount counts the searchable elements reachable from the entry
+// point by following connections at layer 0, which is how far a search
+// can get. A count well below Len signals a fragmented graph, e.g. after
+// heavy deletion without Compact, or after SetNeighbors. The traversal
+// passes through deleted and hidden nodes without counting them.
+//
+// It is a breadth-first traversal that stops after visiting the
+// WithReachableLimit number of nodes, in which case capped is true and n
+// is a lower bound. It costs one visit per node up to the limit, against
+// one pass over every node and connection for a full connectivity check.
+func (h *HNSW) ReachableCount() (n int, capped bool) {
+	if len(h.Layers) == 0 {
+		return 0, false
+	}
+	layer := h.Layers[0]
+	if _, ok := layer[h.EnterPoint]; !ok {
+		return 0, false
+	}
+	limit := h.reachableLimit()
+	visited := map[int]bool{}
+	var queue []int
+	visit := func(id int) {
+		visited[id] = true
+		queue = append(queue, id)
+		if h.searchable(id) {
+			n++
+		}
+	}
+	visit(h.EnterPoint)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, c := range layer[id].Candidates {
+			if visited[c.NodeID] {
+				continue
+			}
+			if _, ok := layer[c.NodeID]; !ok {
+				continue // Dangling connection
+			}
+			if len(visited) >= limit {
+				return n, true
+			}
+			visit(c.NodeID)
+		}
+	}
+	return n, false
+}
@@ -2,31 +2,80 @@ package hnsw
 
 import (
 	"container/heap"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
-	"sync"
+	"sort"
+	"time"
 
 	hnswheap "github.com/lblclass/hnswgo/util/heap"
 
 	"github.com/lblclass/hnswgo/models"
 )
 
-// HNSW is the main struct representing the graph.
+// ErrNotFound is returned when an element ID is not present in the index.
+var ErrNotFound = errors.New("hnsw: element not found")
+
+// HNSW is the main struct representing the graph. It does no locking and
+// is not safe for concurrent use; wrap it in a SafeHNSW to share it between
+// goroutines.
+//
+// Nodes are keyed by Element.ID everywhere, from Layers to search results,
+// and no operation renumbers them: Compact only removes deleted nodes and
+// Optimize only moves data in memory, so IDs, and the labels of
+// InsertLabeled, stay valid for the lifetime of an element.
 type HNSW struct {
 	Layers          []map[int]*hnswheap.CandidateHeap // Connections at each layer
 	EnterPoint      int                               // Entry point ID
 	M               int
 	maxConnections  int     // Maximum connections per element
 	EfConstruction  int     // Candidate list size
+	DefaultEf       int     // Search ef of KNNSearch; 0 uses K
 	NormalizationML float64 // Level normalization factor
 	MaxLayers       int
+	Metric          Metric                 // Distance function; empty means L2
+	Dim             int                    // Vector dimension; 0 until fixed by the first insert
 	Elements        map[int]models.Element // Element data
-	mu              sync.RWMutex
+	Levels          map[int]int            // Top layer reached by each element
+	IntVectors      map[int][]int32        // Integer vectors, integer metrics only
+	Float16         bool                   // Vectors are stored in HalfVectors; see WithFloat16
+	HalfVectors     map[int][]uint16       // Half-precision vectors, Float16 only
+	Kahan           bool                   // Distances use compensated sums; see WithKahanSummation
+	Deleted         map[int]bool           // Tombstoned elements, removed by Compact
+	Hidden          map[int]bool           // Elements hidden from searches by SoftDelete
+	Labels          map[int]int            // External label of elements added by InsertLabeled
+	Metadata        map[string]string      // Free-form user data, saved with the index
+	norms           map[int]float64        // Cached vector norms, Cosine and Angular only
+	labelIDs        map[int]int            // Internal ID of each label, derived from Labels
+	nextID          int                    // Lower bound of the next ID InsertLabeled assigns
+	freeIDs         []int                  // Internal IDs freed by deleting labeled elements, reused last first
+
+	progress         ProgressFunc // Bulk build progress callback
+	progressInterval int
+	rng              *rand.Rand        // Level generator; nil uses the global source
+	seed             int64             // Seed of rng, restored by Reset
+	maxElements      int               // Live element limit; 0 is unbounded
+	evict            EvictionPolicy    // Picks the element evicted at the limit
+	connPolicy       ConnectionPolicy  // Picks the connections a full list keeps; nil keeps the closest
+	exactEpsilon     float64           // Tolerance of ExactMatch
+	project          ProjectFunc       // Applied to vectors before storage; nil is identity
+	pruneAlpha       float64           // Neighbor pruning relaxation; 0 means 1
+	improvementDelta float64           // Margin by which a new connection must beat the farthest one
+	symmetricRepair  bool              // Compact also runs RepairSymmetry
+	deferPruning     bool              // BuildBulk links without eviction, then runs pruneAll
+	refine           DistanceFunc      // Exact distance for selection and ranking; nil uses Metric
+	batchDist        BatchDistanceFunc // Layer search distances in batches; nil computes them one by one
+	vectorStore      VectorStore       // Source of vectors; nil uses Elements
+	amongThreshold   int               // Largest set KNNSearchAmong scans; 0 is the default
+	reachableMax     int               // Nodes ReachableCount visits at most; 0 is the default
+	sortedBelow      int               // Searches with a smaller ef keep results in a sorted slice; 0 is the default
+	insertOrder      []int             // Insertion order, kept for eviction only
 }
 
 // NewHNSW initializes an HNSW graph.
-func NewHNSW(efConstruction, M, maxLayers int, nm float64) *HNSW {
-	return &HNSW{
+func NewHNSW(efConstruction, M, maxLayers int, nm float64, opts ...Option) *HNSW {
+	h := &HNSW{
 		Layers:          []map[int]*hnswheap.CandidateHeap{},
 		EnterPoint:      -1,
 		M:               M,
@@ -35,45 +84,138 @@ func NewHNSW(efConstruction, M, maxLayers int, nm float64) *HNSW {
 		MaxLayers:       maxLayers,
 		NormalizationML: nm,
 		Elements:        make(map[int]models.Element),
-		mu:              sync.RWMutex{},
+		Levels:          make(map[int]int),
+		IntVectors:      make(map[int][]int32),
+		HalfVectors:     make(map[int][]uint16),
+		Deleted:         make(map[int]bool),
+		Hidden:          make(map[int]bool),
+		Labels:          make(map[int]int),
+		norms:           make(map[int]float64),
+		labelIDs:        make(map[int]int),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Insert adds a new element into the HNSW graph. The vector must not be
+// empty, or Insert fails with ErrEmptyVector. It is passed through the
+// WithProjection function, if any, and must then have the index dimension;
+// otherwise Insert fails with ErrDimensionMismatch.
+func (h *HNSW) Insert(q models.Element) error {
+	_, err := h.InsertWithResult(q)
+	return err
+}
+
+// InsertResult describes the effect of one insert on the graph.
+type InsertResult struct {
+	Level             int  // Top layer assigned to the element
+	NewTopLayer       bool // The graph grew one or more layers
+	EnterPointChanged bool // The entry point moved, usually to the element
+}
+
+// InsertWithResult is Insert that also reports the level the element got
+// and whether it changed the top of the graph, e.g. for build progress
+// displays.
+func (h *HNSW) InsertWithResult(q models.Element) (InsertResult, error) {
+	q, err := h.prepare(q)
+	if err != nil {
+		return InsertResult{}, err
 	}
+	return h.insertPrepared(q), nil
 }
 
-// Insert adds a new element into the HNSW graph.
-func (h *HNSW) Insert(q models.Element) {
+// insertPrepared inserts q, already passed through prepare, at a randomly
+// generated level.
+func (h *HNSW) insertPrepared(q models.Element) InsertResult {
 	level := h.generateLevel()
 	if level > h.MaxLayers {
 		level = h.MaxLayers
 	}
-	h.Elements[q.ID] = q
+	return h.insertAtLevel(q, level)
+}
+
+// InsertUnique inserts q unless a live element already lies within
+// Euclidean distance epsilon of its vector, after projection. In that case
+// nothing is inserted and InsertUnique returns false and the ID of that
+// element; otherwise it returns true and q.ID.
+//
+// The check is the graph search of ExactMatch, not a scan, so it costs
+// about one search but can miss an existing duplicate: recall of the check
+// is that of a search with ef = EfConstruction. Under InnerProduct, where a
+// vector need not be its own nearest neighbor, it misses more often.
+// InsertUnique therefore keeps duplicates out on a best-effort basis;
+// dedupe on an exact key first when none may get in.
+func (h *HNSW) InsertUnique(q models.Element, epsilon float64) (bool, int, error) {
+	q, err := h.prepare(q)
+	if err != nil {
+		return false, -1, err
+	}
+	if id, ok := h.exactMatch(q.Embeddings, epsilon); ok {
+		return false, id, nil
+	}
+	h.insertPrepared(q)
+	return true, q.ID, nil
+}
+
+// InsertWithLevel adds a new element at the given top level instead of a
+// randomly generated one, e.g. to reproduce a graph built elsewhere. The
+// level must be within [0, MaxLayers].
+func (h *HNSW) InsertWithLevel(q models.Element, level int) error {
+	if level < 0 || level > h.MaxLayers {
+		return fmt.Errorf("hnsw: level %d out of range [0, %d]", level, h.MaxLayers)
+	}
+	q, err := h.prepare(q)
+	if err != nil {
+		return err
+	}
+	h.insertAtLevel(q, level)
+	return nil
+}
+
+// insertAtLevel links q into layers 0 through level.
+func (h *HNSW) insertAtLevel(q models.Element, level int) InsertResult {
+	layers, enterPoint := len(h.Layers), h.EnterPoint
+	if h.Deleted[q.ID] {
+		// Reusing a tombstoned ID: purge the old node first.
+		h.unlinkNodes(map[int]bool{q.ID: true})
+	}
+	if h.maxElements > 0 {
+		h.makeRoom(q)
+	}
+	// Descend from a valid entry point even if the fields were edited or
+	// decoded by hand.
+	h.repairEnterPoint()
+	h.store(q)
+	h.Levels[q.ID] = level
+	if h.Dim == 0 {
+		h.Dim = len(q.Embeddings)
+	}
 	topLevel := len(h.Layers) - 1
 	ep := h.EnterPoint
 	if topLevel <= level {
-		// Add new layers if needed.
+		// Add new layers if needed. This also bootstraps an empty index:
+		// the first element creates the layers and becomes the entry
+		// point, and nothing else initializes them, so inserts serialized
+		// as SafeHNSW does bootstrap the graph exactly once.
 		for i := len(h.Layers); i <= level; i++ {
-			tmp := hnswheap.CandidateHeap{
-				Compare: "big",
-			}
-			heap.Init(&tmp)
 			h.Layers = append(h.Layers, map[int]*hnswheap.CandidateHeap{
-				q.ID: &tmp,
+				q.ID: hnswheap.NewBigCandidatesHeap(),
 			})
 		}
 		h.EnterPoint = q.ID
 	} else {
 		// 如果topLevel大于level，则ep需要有些变化。
 		for lc := topLevel; lc > level; lc-- {
-			tmpEp := h.SearchLayer(q, ep, 1, lc)
-			ep = tmpEp.Candidates[0].NodeID
+			if tmpEp := h.SearchLayer(q, ep, 1, lc); tmpEp.Len() > 0 {
+				ep = tmpEp.Candidates[0].NodeID
+			}
 		}
 	}
 
 	for lc := min(topLevel, level); lc >= 0; lc-- {
-		tmp := hnswheap.CandidateHeap{
-			Compare: "big",
-		}
-		heap.Init(&tmp)
-		h.Layers[lc][q.ID] = &tmp
+		h.Layers[lc][q.ID] = hnswheap.NewBigCandidatesHeap()
 		tmpNeighbors := h.SearchLayer(q, ep, h.EfConstruction, lc)
 		neighbors := tmpNeighbors.ExtractHeapData()
 		selected := h.SelectNeighborsHeuristic(q, neighbors, h.M, lc, true, true)
@@ -83,42 +225,359 @@ func (h *HNSW) Insert(q models.Element) {
 			h.addConnection(n, q.ID, lc)
 			h.addConnection(q.ID, n, lc)
 		}
-		ep = neighbors[0]
+		if len(neighbors) > 0 {
+			ep = neighbors[0]
+		}
 	}
+	return InsertResult{
+		Level:             level,
+		NewTopLayer:       len(h.Layers) > layers,
+		EnterPointChanged: h.EnterPoint != enterPoint,
+	}
+}
 
+// InsertBatch inserts elems in order, reporting progress if configured with
+// WithProgress. It stops at the first element Insert rejects; the elements
+// before it stay inserted.
+func (h *HNSW) InsertBatch(elems []models.Element) error {
+	for i, e := range elems {
+		if err := h.Insert(e); err != nil {
+			return err
+		}
+		h.reportProgress(i+1, len(elems))
+	}
+	return nil
+}
+
+// reportProgress calls the progress callback every progressInterval
+// elements and on the last one.
+func (h *HNSW) reportProgress(done, total int) {
+	if h.progress != nil && (done%h.progressInterval == 0 || done == total) {
+		h.progress(done, total)
+	}
 }
 
-// searchLayer finds nearest neighbors in the specified layer.
+// Get returns the stored element with the given ID. Deleted elements are
+// not returned.
+func (h *HNSW) Get(id int) (models.Element, bool) {
+	if _, ok := h.Elements[id]; !ok || h.Deleted[id] {
+		return models.Element{}, false
+	}
+	return h.element(id), true
+}
+
+// Len returns the number of searchable elements: those neither deleted nor
+// hidden by SoftDelete. Stats reports the totals.
+func (h *HNSW) Len() int {
+	return len(h.Elements) - len(h.Deleted) - len(h.Hidden)
+}
+
+// LevelOf returns the top layer reached by element id, or -1 if it is not
+// in the index.
+func (h *HNSW) LevelOf(id int) int {
+	level, ok := h.Levels[id]
+	if !ok {
+		return -1
+	}
+	return level
+}
+
+// ElementsAtLayer returns the IDs of the nodes present at the given layer,
+// deleted ones included, in ascending order. Every node at a layer is also
+// at the layers below it, so the sizes shrink from layer 0 up, by a factor
+// of about exp(1/NormalizationML) per layer. It fails if the layer is out
+// of range.
+func (h *HNSW) ElementsAtLayer(layer int) ([]int, error) {
+	if layer < 0 || layer >= len(h.Layers) {
+		return nil, fmt.Errorf("hnsw: layer %d out of range [0, %d)", layer, len(h.Layers))
+	}
+	return sortedKeys(h.Layers[layer]), nil
+}
+
+// Neighbors returns a copy of the connections of node id at the given
+// layer, sorted by ascending distance, ties by ID. It returns nil if the
+// node is not present at that layer.
+func (h *HNSW) Neighbors(id, layer int) []models.Candidate {
+	if layer < 0 || layer >= len(h.Layers) {
+		return nil
+	}
+	conns, ok := h.Layers[layer][id]
+	if !ok {
+		return nil
+	}
+	res := append([]models.Candidate(nil), conns.Candidates...)
+	sortCandidates(res)
+	return res
+}
+
+// neighborhoodLimit bounds the number of nodes Neighborhood returns, so that
+// a large hop count on a dense graph cannot enumerate the whole layer.
+const neighborhoodLimit = 10000
+
+// Neighborhood returns the nodes reachable from id within hops connections
+// at the given layer, mapped to their hop distance; id itself maps to 0.
+// It is a breadth-first traversal of the outgoing connections, so a node's
+// distance is the fewest hops to reach it, and deleted and hidden nodes
+// are included like any other. The traversal stops once 10000 nodes are
+// found, leaving out part of the last hop reached. It returns nil if id is
+// not present at that layer.
+func (h *HNSW) Neighborhood(id, hops, layer int) map[int]int {
+	if layer < 0 || layer >= len(h.Layers) {
+		return nil
+	}
+	if _, ok := h.Layers[layer][id]; !ok {
+		return nil
+	}
+	res := map[int]int{id: 0}
+	frontier := []int{id}
+	for hop := 1; hop <= hops && len(frontier) > 0; hop++ {
+		var next []int
+		for _, n := range frontier {
+			for _, c := range h.Layers[layer][n].Candidates {
+				if _, ok := res[c.NodeID]; ok {
+					continue
+				}
+				if _, ok := h.Layers[layer][c.NodeID]; !ok {
+					continue // Dangling connection
+				}
+				if len(res) >= neighborhoodLimit {
+					return res
+				}
+				res[c.NodeID] = hop
+				next = append(next, c.NodeID)
+			}
+		}
+		frontier = next
+	}
+	return res
+}
+
+// SetNeighbors replaces the connections of node id at the given layer with
+// neighbors, bypassing search and neighbor selection, e.g. to reproduce a
+// graph built by another library. Together with InsertWithLevel, which
+// gives each element its level in that graph, and EnterPoint set to its
+// entry point, it reconstructs the graph exactly. The node and every
+// neighbor must be present at the layer, and the list must not contain id
+// itself or duplicates; otherwise nothing is changed. Distances are
+// computed under the index metric. Lists longer than 2*M are accepted and
+// are trimmed by later inserts.
+func (h *HNSW) SetNeighbors(id, layer int, neighbors []int) error {
+	if layer < 0 || layer >= len(h.Layers) {
+		return fmt.Errorf("hnsw: layer %d out of range [0, %d)", layer, len(h.Layers))
+	}
+	conns, ok := h.Layers[layer][id]
+	if !ok {
+		return fmt.Errorf("element %d at layer %d: %w", id, layer, ErrNotFound)
+	}
+	seen := make(map[int]bool, len(neighbors))
+	for _, n := range neighbors {
+		if _, ok := h.Layers[layer][n]; !ok {
+			return fmt.Errorf("neighbor %d at layer %d: %w", n, layer, ErrNotFound)
+		}
+		if n == id || seen[n] {
+			return fmt.Errorf("hnsw: element %d: invalid or repeated neighbor %d", id, n)
+		}
+		seen[n] = true
+	}
+	conns.Candidates = conns.Candidates[:0]
+	for _, n := range neighbors {
+		conns.Candidates = append(conns.Candidates, models.Candidate{NodeID: n, Distance: h.nodeDistance(id, n)})
+	}
+	conns.Fix()
+	return nil
+}
+
+// layerSearch holds optional controls for a single-layer search.
+type layerSearch struct {
+	maxHops     int       // Stop after this many candidate expansions; 0 is unlimited
+	deadline    time.Time // Stop once passed, checked before each expansion; zero is unlimited
+	skipDeleted bool      // Traverse deleted and hidden nodes but keep them out of the results
+	hitLimit    bool      // Set when the search stopped early on a limit
+
+	// filter, if set, keeps nodes for which it returns false out of the
+	// results. They are still traversed.
+	filter func(id int) bool
+
+	// dist, if set, is the query distance function of the search, so that
+	// per-query work is shared across layers.
+	dist func(id int) float64
+
+	// match, if set, ends the search at the first admitted node for which
+	// it returns true, recording that node in matched and setting found.
+	match   func(id int, d float64) bool
+	matched int
+	found   bool
+
+	// batch, if set with dist, computes the distances to several nodes at
+	// once; searchLayer then uses it for the neighbors of each expanded
+	// node. Without dist, the index batch distance, if any, is used.
+	batch func(ids []int) []float64
+
+	// trace, if set, records the layer at which each node was first
+	// visited. searchKNN shares it with the upper layers.
+	trace map[int]int
+
+	// upperEf, if set, is the ef of searchKNN at each upper layer, indexed
+	// by layer. Missing and non-positive entries mean the usual 1.
+	upperEf []int
+}
+
+// visit records the first visit of node id at layer lc if tracing.
+func (opts *layerSearch) visit(id, lc int) {
+	if opts == nil || opts.trace == nil {
+		return
+	}
+	if _, ok := opts.trace[id]; !ok {
+		opts.trace[id] = lc
+	}
+}
+
+// matches reports whether c satisfies the match hook of opts, recording it.
+func (h *HNSW) matches(opts *layerSearch, c models.Candidate) bool {
+	if opts == nil || opts.match == nil || !opts.match(c.NodeID, c.Distance) {
+		return false
+	}
+	opts.matched = c.NodeID
+	opts.found = true
+	return true
+}
+
+// admits reports whether node id may enter the results of a layer search.
+func (h *HNSW) admits(opts *layerSearch, id int) bool {
+	if opts == nil {
+		return true
+	}
+	if opts.filter != nil && !opts.filter(id) {
+		return false
+	}
+	return !opts.skipDeleted || (!h.Deleted[id] && !h.Hidden[id])
+}
+
+// insertSorted adds c to W, a result heap of at most ef candidates kept
+// sorted by descending distance, dropping the farthest when W is full. A
+// slice sorted that way is a valid max-heap, so W can still be used as one.
+func insertSorted(W *hnswheap.CandidateHeap, c models.Candidate, ef int) {
+	s := W.Candidates
+	p := 0
+	for p < len(s) && s[p].Distance >= c.Distance {
+		p++
+	}
+	if len(s) >= ef {
+		// Drop s[0], the farthest, shifting the farther part left.
+		copy(s, s[1:p])
+		s[p-1] = c
+		return
+	}
+	s = append(s, models.Candidate{})
+	copy(s[p+1:], s[p:])
+	s[p] = c
+	W.Candidates = s
+}
+
+// SearchLayer finds the ef nearest neighbors of q in layer lc, starting from
+// entryPoint. The result holds at least the entry point, or a closer node,
+// unless entryPoint is not in layer lc, in which case it is empty.
 func (h *HNSW) SearchLayer(q models.Element, entryPoint int, ef int, lc int) *hnswheap.CandidateHeap {
+	return h.searchLayer(q, entryPoint, ef, lc, nil)
+}
+
+// SearchLayerCandidates is SearchLayer returning the candidates as a slice
+// sorted by ascending distance, ties by ascending ID, for building custom
+// search strategies on the layer search without depending on the heap
+// layout. Like SearchLayer it includes deleted and hidden nodes. The slice
+// is a copy and may be kept.
+func (h *HNSW) SearchLayerCandidates(q models.Element, entryPoint, ef, layer int) []models.Candidate {
+	W := h.searchLayer(q, entryPoint, ef, layer, nil)
+	res := append([]models.Candidate(nil), W.Candidates...)
+	sortCandidates(res)
+	return res
+}
+
+// searchLayer is SearchLayer with optional controls; opts may be nil.
+func (h *HNSW) searchLayer(q models.Element, entryPoint int, ef int, lc int, opts *layerSearch) *hnswheap.CandidateHeap {
+	if lc < 0 || lc >= len(h.Layers) {
+		return hnswheap.NewBigCandidatesHeap()
+	}
+	if _, ok := h.Layers[lc][entryPoint]; !ok {
+		return hnswheap.NewBigCandidatesHeap()
+	}
+	dist := h.queryDistance(q)
+	batch := h.batchQueryDistance(q)
+	if opts != nil && opts.dist != nil {
+		dist, batch = opts.dist, opts.batch
+	}
 	V := map[int]bool{entryPoint: true} // set of visited elements
+	var fresh []int                     // Unvisited neighbors of the expanded node
+	opts.visit(entryPoint, lc)
 	qCandidate := models.Candidate{
 		NodeID:   entryPoint,
-		Distance: h.Distance(q, h.Elements[entryPoint]),
+		Distance: dist(entryPoint),
 	}
 	C := hnswheap.NewSmallCandidatesHeap()
 	heap.Init(C)
 	heap.Push(C, qCandidate)
 	W := hnswheap.NewBigCandidatesHeap()
 	heap.Init(W)
-	heap.Push(W, qCandidate)
+	sorted := ef < h.sortedResultsLimit()
+	if h.admits(opts, entryPoint) {
+		heap.Push(W, qCandidate)
+		if h.matches(opts, qCandidate) {
+			return W
+		}
+	}
+	hops := 0
 	for C.Len() > 0 {
+		if opts != nil && opts.maxHops > 0 && hops >= opts.maxHops {
+			opts.hitLimit = true
+			break
+		}
+		if opts != nil && !opts.deadline.IsZero() && time.Now().After(opts.deadline) {
+			opts.hitLimit = true
+			break
+		}
+		hops++
 		nc := heap.Pop(C).(models.Candidate)
-		fc := W.Candidates[0]
-		if nc.Distance > fc.Distance {
+		// Stop once the closest unexpanded candidate is farther than every
+		// result. W can only be short of ef when nodes were kept out of it.
+		if W.Len() >= ef && nc.Distance > W.Candidates[0].Distance {
 			break
 		}
-		for i := 0; i < h.Layers[lc][nc.NodeID].Len(); i++ {
-			vNode := h.Layers[lc][nc.NodeID].Candidates[i].NodeID
-			if _, ok := V[vNode]; ok {
+		fresh = fresh[:0]
+		for _, c := range h.Layers[lc][nc.NodeID].Candidates {
+			if _, ok := V[c.NodeID]; ok {
 				continue
 			}
-			V[vNode] = true
-			if (fc.Distance > h.Distance(q, h.Elements[vNode])) || (W.Len() < ef) {
-				tmpC := models.Candidate{NodeID: vNode, Distance: h.Distance(q, h.Elements[vNode])}
+			V[c.NodeID] = true
+			opts.visit(c.NodeID, lc)
+			fresh = append(fresh, c.NodeID)
+		}
+		var ds []float64
+		if batch != nil && len(fresh) > 0 {
+			ds = batch(fresh)
+		}
+		for i, vNode := range fresh {
+			var d float64
+			if ds != nil {
+				d = ds[i]
+			} else {
+				d = dist(vNode)
+			}
+			if W.Len() < ef || d < W.Candidates[0].Distance {
+				tmpC := models.Candidate{NodeID: vNode, Distance: d}
 				heap.Push(C, tmpC)
-				heap.Push(W, tmpC)
-				if W.Len() > ef {
-					heap.Pop(W)
+				if !h.admits(opts, vNode) {
+					continue
+				}
+				if sorted {
+					insertSorted(W, tmpC, ef)
+				} else {
+					heap.Push(W, tmpC)
+					if W.Len() > ef {
+						heap.Pop(W)
+					}
+				}
+				if h.matches(opts, tmpC) {
+					return W
 				}
 			}
 		}
@@ -134,28 +593,38 @@ func (h *HNSW) SelectNeighborsSimple(q models.Element, candidates []int) []int {
 	return candidates[:min(len(candidates), h.M)]
 }
 
-// addConnection adds a connection to the graph.
+// addConnection adds a connection to the graph. A full connection list
+// keeps the connections chosen by the WithConnectionPolicy policy, by
+// default the closest ones; the default only replaces its farthest
+// connection with one closer by more than the WithImprovementDelta margin.
 func (h *HNSW) addConnection(from, to, layer int) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	ft := h.Distance(h.Elements[from], h.Elements[to])
+	ft := h.nodeDistance(from, to)
 	toCandidate := models.Candidate{
 		NodeID:   to,
 		Distance: ft,
 	}
-	if h.Layers[layer][from].Len() < h.maxConnections {
-		heap.Push(h.Layers[layer][from], toCandidate)
-	} else {
-		if ft < h.Layers[layer][from].Candidates[0].Distance {
-			heap.Pop(h.Layers[layer][from])
-			heap.Push(h.Layers[layer][from], toCandidate)
-		}
+	conns := h.Layers[layer][from]
+	switch {
+	case conns.Len() < h.maxConnections:
+		heap.Push(conns, toCandidate)
+	case h.connPolicy != nil:
+		candidates := append(append([]models.Candidate(nil), conns.Candidates...), toCandidate)
+		keep := h.connPolicy(h, from, layer, candidates, h.maxConnections)
+		conns.Candidates = append(conns.Candidates[:0], keep[:min(len(keep), h.maxConnections)]...)
+		conns.Fix()
+	case conns.Candidates[0].Distance-ft > h.improvementDelta:
+		heap.Pop(conns)
+		heap.Push(conns, toCandidate)
 	}
 }
 
 // generateLevel determines the level for a new element.
 func (h *HNSW) generateLevel() int {
-	return int(math.Floor(-math.Log(rand.Float64()) * h.NormalizationML))
+	r := rand.Float64
+	if h.rng != nil {
+		r = h.rng.Float64
+	}
+	return int(math.Floor(-math.Log(r()) * h.NormalizationML))
 }
 
 // min returns the smaller of two integers.
@@ -166,16 +635,44 @@ func min(a, b int) int {
 	return b
 }
 
+// Distance returns the distance between two elements under the index metric.
+// Integer metrics compare the stored integer vectors of e1.ID and e2.ID. An
+// empty vector, which Insert rejects but a VectorStore may still serve for
+// an ID it does not hold yet, is at +Inf from everything.
 func (h *HNSW) Distance(e1, e2 models.Element) float64 {
-	sum := 0.0
-	for i := range e1.Embeddings {
-		diff := e1.Embeddings[i] - e2.Embeddings[i]
-		sum += diff * diff
+	if h.Metric.isInt() {
+		return h.intDistance(h.IntVectors[e1.ID], h.IntVectors[e2.ID])
 	}
-	return math.Sqrt(sum)
+	return h.vectorDistance(e1.Embeddings, e2.Embeddings)
 }
 
-// SelectNeighborsHeuristic implements Algorithm 4.
+// vectorDistance returns the distance between float vectors under the
+// index metric, +Inf if either is empty. Without that check an empty vector
+// would be at distance 0 from everything under L2 and InnerProduct, and
+// draw the links of every insert to itself.
+func (h *HNSW) vectorDistance(a, b []float64) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return math.Inf(1)
+	}
+	switch h.Metric {
+	case Cosine, Angular:
+		return h.angle(h.cosineDistance(a, b))
+	case InnerProduct:
+		return -h.dot(a, b)
+	default:
+		return math.Sqrt(h.squaredL2(a, b))
+	}
+}
+
+// SelectNeighborsHeuristic implements Algorithm 4 of the HNSW paper.
+// Candidates are visited in ascending distance to q, and a candidate e is
+// selected only if it is closer to q than to every element already
+// selected; otherwise it is discarded. With keepPrunedConnections, the
+// closest discarded candidates fill the remaining slots up to M. The result
+// is returned in selection order.
+//
+// The distance to selected elements is scaled by the WithPruningAlpha
+// factor before the comparison. The default alpha = 1 is the rule above.
 func (h *HNSW) SelectNeighborsHeuristic(
 	q models.Element,
 	candidates []int,
@@ -184,46 +681,66 @@ func (h *HNSW) SelectNeighborsHeuristic(
 	extendCandidates bool,
 	keepPrunedConnections bool,
 ) []int {
-	R := make(map[int]bool) // Result set
+	// R keeps selection order so that construction is deterministic.
+	R := make([]int, 0, M) // Result set
 	W := hnswheap.NewSmallCandidatesHeap()
 	heap.Init(W)
 
-	// Add initial candidates to the queue.
+	qDist, pairDist := h.queryDistance(q), h.nodeDistance
+	if h.refine != nil {
+		qDist = h.refineQuery(q)
+		pairDist = func(a, b int) float64 { return h.refine(h.element(a), h.element(b)) }
+	}
+	alpha := h.pruneAlpha
+	if alpha == 0 {
+		alpha = 1
+	}
+
+	// Add initial candidates to the queue, each at most once.
+	queued := map[int]bool{}
 	for _, c := range candidates {
-		dist := h.Distance(q, h.Elements[c])
+		if queued[c] {
+			continue
+		}
+		dist := qDist(c)
 		heap.Push(W, models.Candidate{NodeID: c, Distance: dist})
+		queued[c] = true
 	}
 
 	// Extend candidates by their neighbors if needed.
 	if extendCandidates {
-		neighbors := map[int]bool{}
 		for _, c := range candidates {
 			for _, neighborStruct := range h.Layers[layer][c].Candidates {
 				neighbor := neighborStruct.NodeID
-				if _, exists := neighbors[neighbor]; !exists {
-					dist := h.Distance(q, h.Elements[neighbor])
+				if _, exists := queued[neighbor]; !exists {
+					dist := qDist(neighbor)
 					heap.Push(W, models.Candidate{NodeID: neighbor, Distance: dist})
-					neighbors[neighbor] = true
+					queued[neighbor] = true
 				}
 			}
 		}
 	}
 
-	Wd := hnswheap.NewSmallCandidatesHeap() // Discarded candidates
+	// Discarded candidates, keyed by distance to q like W so that kept
+	// pruned connections are the closest ones.
+	Wd := hnswheap.NewSmallCandidatesHeap()
 	heap.Init(Wd)
 
 	// Process candidates.
 	for W.Len() > 0 && len(R) < M {
 		e := heap.Pop(W).(models.Candidate)
+		// e.Distance is dist(e, q); e is dropped if some selected r has
+		// alpha * dist(e, r) < dist(e, q). The outcome does not depend on
+		// R's order.
 		closer := true
-		for r := range R {
-			if h.Distance(h.Elements[e.NodeID], h.Elements[r]) < e.Distance {
+		for _, r := range R {
+			if alpha*pairDist(e.NodeID, r) < e.Distance {
 				closer = false
 				break
 			}
 		}
 		if closer {
-			R[e.NodeID] = true
+			R = append(R, e.NodeID)
 		} else {
 			heap.Push(Wd, e)
 		}
@@ -233,26 +750,136 @@ func (h *HNSW) SelectNeighborsHeuristic(
 	if keepPrunedConnections {
 		for Wd.Len() > 0 && len(R) < M {
 			e := heap.Pop(Wd).(models.Candidate)
-			R[e.NodeID] = true
+			R = append(R, e.NodeID)
 		}
 	}
 
-	// Convert result set to slice.
-	result := make([]int, 0, len(R))
-	for r := range R {
-		result = append(result, r)
-	}
-	return result
+	return R
 }
 
+// KNNSearch finds the K approximate nearest neighbors of q, using the
+// default ef set by SetDefaultEf as the size of the dynamic candidate list
+// at layer 0, or K if no default is set or it is smaller than K. Like every
+// KNN search of the index, it returns IDs sorted by ascending distance to
+// q, with equal distances ordered by ascending ID.
 func (h *HNSW) KNNSearch(q models.Element, K int) []int {
-	// W := make(hnswheap.SmallCandidates, 0)
+	return h.KNNSearchWithEf(q, K, max(h.DefaultEf, K))
+}
+
+// SetDefaultEf sets the search ef KNNSearch uses, separately from the
+// EfConstruction of inserts. It is saved with the index. At query time it
+// is raised to K when smaller; ef <= 0 removes the default, so K is used.
+func (h *HNSW) SetDefaultEf(ef int) {
+	h.DefaultEf = max(ef, 0)
+}
+
+// KNNSearchWithEf finds the K approximate nearest neighbors of q with an
+// explicit search ef. Larger ef improves recall at the cost of latency; ef is
+// raised to K if smaller. Deleted nodes are traversed but never take a place
+// in the candidate list, so ef counts live nodes and recall does not drop as
+// deletions accumulate; the cost is more hops per search, in proportion to
+// Stats.TombstoneRatio.
+func (h *HNSW) KNNSearchWithEf(q models.Element, K, ef int) []int {
+	return candidateIDs(h.searchKNN(q, K, ef, nil))
+}
+
+// KNNSearchWithBudget is KNNSearchWithEf with at most maxHops candidate
+// expansions at layer 0. When the budget runs out the best candidates found
+// so far are returned and exhausted is true.
+func (h *HNSW) KNNSearchWithBudget(q models.Element, K, ef, maxHops int) (res []int, exhausted bool) {
+	opts := &layerSearch{maxHops: maxHops}
+	res = candidateIDs(h.searchKNN(q, K, ef, opts))
+	return res, opts.hitLimit
+}
+
+// KNNSearchTimed is KNNSearchWithEf limited to about budget of wall time,
+// counted from the call. When the budget runs out the best candidates found
+// so far are returned and timedOut is true; otherwise the search ran to
+// completion and the result is that of KNNSearchWithEf. The clock is read
+// before each candidate expansion at layer 0, which costs far less than
+// the expansion itself, so the search overruns the budget by about one
+// expansion plus the time to sort the results.
+func (h *HNSW) KNNSearchTimed(q models.Element, K, ef int, budget time.Duration) (res []int, timedOut bool) {
+	opts := &layerSearch{deadline: time.Now().Add(budget)}
+	res = candidateIDs(h.searchKNN(q, K, ef, opts))
+	return res, opts.hitLimit
+}
+
+// searchKNN descends from the entry point to layer 0 and returns at most K
+// live candidates sorted by ascending distance to q, ties by ascending ID.
+// opts apply to the layer 0 search only, except upperEf.
+func (h *HNSW) searchKNN(q models.Element, K, ef int, opts *layerSearch) []models.Candidate {
+	if h.EnterPoint < 0 || len(h.Layers) == 0 {
+		return nil
+	}
+	if ef < K {
+		ef = K
+	}
+	if opts == nil {
+		opts = &layerSearch{}
+	}
+	opts.skipDeleted = true
+	// Callers that pass their own distance search with a query the refine
+	// function cannot see, so only plain searches are re-ranked.
+	refine := h.refine != nil && opts.dist == nil
+	if opts.dist == nil {
+		opts.dist = h.queryDistance(q)
+		opts.batch = h.batchQueryDistance(q)
+	}
+	upper := &layerSearch{dist: opts.dist, batch: opts.batch, trace: opts.trace}
 	ep := h.EnterPoint
 	totalLayers := len(h.Layers)
 	for lc := (totalLayers - 1); lc >= 1; lc-- {
-		W := h.SearchLayer(q, ep, 1, lc)
-		ep = W.Candidates[0].NodeID
+		// Only empty if ep is missing from lc, which the entry point
+		// invariant rules out; keep descending from ep rather than panic.
+		layerEf := 1
+		if lc < len(opts.upperEf) && opts.upperEf[lc] > 0 {
+			layerEf = opts.upperEf[lc]
+		}
+		if W := h.searchLayer(q, ep, layerEf, lc, upper); W.Len() > 0 {
+			ep = nearest(W.Candidates)
+		}
+	}
+	W := h.searchLayer(q, ep, ef, 0, opts)
+	res := append([]models.Candidate(nil), W.Candidates...)
+	if refine {
+		dist := h.refineQuery(q)
+		for i := range res {
+			res[i].Distance = dist(res[i].NodeID)
+		}
+	}
+	sortCandidates(res)
+	return res[:min(len(res), K)]
+}
+
+// nearest returns the ID of the closest of candidates, the lowest ID on
+// ties. candidates must not be empty.
+func nearest(candidates []models.Candidate) int {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.Distance < best.Distance || c.Distance == best.Distance && c.NodeID < best.NodeID {
+			best = c
+		}
+	}
+	return best.NodeID
+}
+
+// sortCandidates sorts candidates by ascending distance, ties by ascending
+// ID, the order of every search result.
+func sortCandidates(candidates []models.Candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Distance == candidates[j].Distance {
+			return candidates[i].NodeID < candidates[j].NodeID
+		}
+		return candidates[i].Distance < candidates[j].Distance
+	})
+}
+
+// candidateIDs returns the node IDs of candidates, in order.
+func candidateIDs(candidates []models.Candidate) []int {
+	res := make([]int, len(candidates))
+	for i, c := range candidates {
+		res[i] = c.NodeID
 	}
-	W := h.SearchLayer(q, ep, K, 0)
-	return W.TopKMinVal(K)
+	return res
 }
@@ -0,0 +1,255 @@
+package hnsw
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// randomVector returns a vector of d values drawn uniformly from [0, 1).
+func randomVector(r *rand.Rand, d int) []float64 {
+	v := make([]float64, d)
+	for i := range v {
+		v[i] = r.Float64()
+	}
+	return v
+}
+
+// buildRandom returns an index of n random d-dimensional elements with IDs
+// 0 to n-1, built with a fixed seed.
+func buildRandom(t *testing.T, n, d int, seed int64, opts ...Option) *HNSW {
+	t.Helper()
+	r := rand.New(rand.NewSource(seed))
+	h := NewHNSW(50, 8, 5, 0.48, append([]Option{WithSeed(seed)}, opts...)...)
+	for i := 0; i < n; i++ {
+		if err := h.Insert(models.Element{ID: i, GroupID: i % 10, Embeddings: randomVector(r, d)}); err != nil {
+			t.Fatalf("Insert(%d): %v", i, err)
+		}
+	}
+	return h
+}
+
+func TestKNNSearchDedupZeroK(t *testing.T) {
+	h := buildRandom(t, 100, 4, 1)
+	q := models.Element{Embeddings: []float64{0.5, 0.5, 0.5, 0.5}}
+	key := func(e models.Element) int { return e.GroupID }
+	if res := h.KNNSearchDedup(q, 0, 0, func(e models.Element) string { return e.Msg }); len(res) != 0 {
+		t.Errorf("KNNSearchDedup(K=0) = %v, want none", res)
+	}
+	if res := candidateIDs(searchDistinct(h, q, 3, 0, key)); len(res) != 3 {
+		t.Errorf("searchDistinct(K=3, ef=0) = %v, want 3 results", res)
+	}
+}
+
+func TestKNNSearchDedupFloat16(t *testing.T) {
+	h := buildRandom(t, 200, 4, 2, WithFloat16())
+	q := models.Element{Embeddings: []float64{0.5, 0.5, 0.5, 0.5}}
+	// The key sees the stored vector, so keying on its first coordinate
+	// rounded to a tenth gives at most ten distinct keys.
+	res := h.KNNSearchDedup(q, 20, 20, func(e models.Element) string {
+		if len(e.Embeddings) == 0 {
+			t.Fatalf("element %d: key called without a vector", e.ID)
+		}
+		return string(rune('0' + int(e.Embeddings[0]*10)))
+	})
+	if len(res) == 0 || len(res) > 10 {
+		t.Errorf("KNNSearchDedup = %d results, want 1 to 10", len(res))
+	}
+}
+
+func TestSeededBuildDeterministic(t *testing.T) {
+	var encodings [2][]byte
+	for i := range encodings {
+		data, err := buildRandom(t, 500, 8, 5).GobEncode()
+		if err != nil {
+			t.Fatalf("GobEncode: %v", err)
+		}
+		encodings[i] = data
+	}
+	if !bytes.Equal(encodings[0], encodings[1]) {
+		t.Error("two builds with the same seed encode differently")
+	}
+}
+
+// heuristicIndex returns the index of the hand-computed example of
+// TestSelectNeighborsHeuristic. From q = (0, 0), in ascending distance:
+//
+//	1 (1, 0)      1      selected
+//	2 (2, 0)      2      pruned: 1 from 1
+//	6 (1.5, 1.5)  2.12   pruned: 1.58 from 1
+//	3 (0, 2.5)    2.5    selected: 2.69 from 1
+//	4 (-3, 0)     3      selected: 4 from 1, 3.9 from 3
+//	5 (0, -4)     4      selected: 4.12 from 1, 6.5 from 3, 5 from 4
+//
+// At layer 0, 1 links to 2 and 3 and 4 links to 5, so that extending the
+// candidates 1 and 4 reaches all of them.
+func heuristicIndex(t *testing.T) *HNSW {
+	t.Helper()
+	h := NewHNSW(10, 4, 1, 0.72)
+	vectors := map[int][]float64{1: {1, 0}, 2: {2, 0}, 3: {0, 2.5}, 4: {-3, 0}, 5: {0, -4}, 6: {1.5, 1.5}}
+	for _, id := range sortedKeys(vectors) {
+		if err := h.Insert(models.Element{ID: id, Embeddings: vectors[id]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, links := range map[int][]int{1: {2, 3}, 4: {5}} {
+		conns := hnswheap.NewBigCandidatesHeap()
+		for _, n := range links {
+			heap.Push(conns, models.Candidate{NodeID: n, Distance: h.nodeDistance(id, n)})
+		}
+		h.Layers[0][id] = conns
+	}
+	return h
+}
+
+func TestSelectNeighborsHeuristic(t *testing.T) {
+	h := heuristicIndex(t)
+	q := models.Element{ID: -1, Embeddings: []float64{0, 0}}
+	all := []int{5, 4, 3, 6, 2, 1}
+	for _, tc := range []struct {
+		candidates []int
+		M          int
+		extend     bool
+		keep       bool
+		want       []int
+	}{
+		{all, 6, false, false, []int{1, 3, 4, 5}},
+		{all, 2, false, false, []int{1, 3}},
+		// Kept pruned connections come closest first.
+		{all, 6, false, true, []int{1, 3, 4, 5, 2, 6}},
+		{all, 5, false, true, []int{1, 3, 4, 5, 2}},
+		{[]int{4, 1}, 6, false, false, []int{1, 4}},
+		{[]int{4, 1}, 6, true, false, []int{1, 3, 4, 5}},
+		{[]int{4, 1}, 6, true, true, []int{1, 3, 4, 5, 2}},
+		// Repeated candidates count once.
+		{[]int{1, 2, 2, 1}, 6, false, true, []int{1, 2}},
+	} {
+		got := h.SelectNeighborsHeuristic(q, tc.candidates, tc.M, 0, tc.extend, tc.keep)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("SelectNeighborsHeuristic(%v, M=%d, extend=%v, keep=%v) = %v, want %v",
+				tc.candidates, tc.M, tc.extend, tc.keep, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkPruningAlpha builds an index of 5000 random 24-d vectors for
+// each alpha and reports the build time and the recall@10 at
+// ef 10 and 40 over 200 queries.
+func BenchmarkPruningAlpha(b *testing.B) {
+	const n, d, K = 5000, 24, 10
+	r := rand.New(rand.NewSource(1))
+	elems := make([]models.Element, n)
+	for i := range elems {
+		elems[i] = models.Element{ID: i, Embeddings: randomVector(r, d)}
+	}
+	queries := make([]models.Element, 200)
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, d)}
+	}
+	var truth [][]int
+	for _, alpha := range []float64{1, 1.1, 1.2, 1.5} {
+		b.Run(fmt.Sprintf("alpha=%v", alpha), func(b *testing.B) {
+			var h *HNSW
+			for i := 0; i < b.N; i++ {
+				h = NewHNSW(64, 8, 5, 0.48, WithSeed(1), WithPruningAlpha(alpha))
+				if err := h.InsertBatch(elems); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			if truth == nil {
+				for _, q := range queries {
+					truth = append(truth, h.BruteForceKNN(q, K))
+				}
+			}
+			for _, ef := range []int{10, 40} {
+				b.ReportMetric(h.EvaluateRecall(queries, truth, K, ef), fmt.Sprintf("recall@ef%d", ef))
+			}
+		})
+	}
+}
+
+// TestResetLikeNew fills an index with other data, deletions, hidden and
+// labeled elements, resets it, and checks that it then behaves and builds
+// exactly like a fresh one with the same seed.
+func TestResetLikeNew(t *testing.T) {
+	const n, d, seed = 300, 8, 37
+	h := NewHNSW(50, 8, 5, 0.48, WithSeed(seed))
+	r := rand.New(rand.NewSource(38))
+	for i := 0; i < 200; i++ {
+		if err := h.Insert(models.Element{ID: 1000 + i, Embeddings: randomVector(r, 4)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if err := h.Delete(1000 + i); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.SoftDelete(1100 + i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.InsertLabeled(7, randomVector(r, 4), ""); err != nil {
+		t.Fatal(err)
+	}
+	h.Reset()
+	q := models.Element{Embeddings: randomVector(r, d)}
+	if h.Len() != 0 || h.EnterPoint != -1 || len(h.KNNSearch(q, 10)) != 0 {
+		t.Fatalf("after Reset: Len %d, EnterPoint %d, KNNSearch %v", h.Len(), h.EnterPoint, h.KNNSearch(q, 10))
+	}
+	if _, ok := h.InternalID(7); ok {
+		t.Error("label 7 survived Reset")
+	}
+	// Vectors of another dimension are accepted, as by a fresh index.
+	r = rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		if err := h.Insert(models.Element{ID: i, GroupID: i % 10, Embeddings: randomVector(r, d)}); err != nil {
+			t.Fatalf("Insert(%d) after Reset: %v", i, err)
+		}
+	}
+	fresh := buildRandom(t, n, d, seed)
+	if err := h.Diff(fresh); err != nil {
+		t.Errorf("after Reset and rebuild: %v", err)
+	}
+	if h.Len() != n || h.EnterPoint != fresh.EnterPoint {
+		t.Errorf("Len %d, EnterPoint %d; want %d, %d", h.Len(), h.EnterPoint, n, fresh.EnterPoint)
+	}
+	if got, want := h.KNNSearch(q, 10), fresh.KNNSearch(q, 10); !slices.Equal(got, want) {
+		t.Errorf("KNNSearch = %v, want %v", got, want)
+	}
+}
+
+func TestInsertEmptyVector(t *testing.T) {
+	fresh := NewHNSW(10, 4, 3, 0.72)
+	built := buildRandom(t, 50, 4, 43)
+	for _, h := range []*HNSW{fresh, built} {
+		n, ep, dim := len(h.Elements), h.EnterPoint, h.Dim
+		for _, v := range [][]float64{nil, {}} {
+			if err := h.Insert(models.Element{ID: 100, Embeddings: v}); !errors.Is(err, ErrEmptyVector) {
+				t.Errorf("Insert(%#v) = %v, want ErrEmptyVector", v, err)
+			}
+			if _, err := h.InsertLabeled(1, v, ""); !errors.Is(err, ErrEmptyVector) {
+				t.Errorf("InsertLabeled(%#v) = %v, want ErrEmptyVector", v, err)
+			}
+		}
+		if len(h.Elements) != n || h.EnterPoint != ep || h.Dim != dim {
+			t.Errorf("rejected inserts changed the index: %d elements, entry point %d, Dim %d; want %d, %d, %d",
+				len(h.Elements), h.EnterPoint, h.Dim, n, ep, dim)
+		}
+	}
+	ints := NewHNSW(10, 4, 3, 0.72, WithMetric(IntL2))
+	if err := ints.InsertInt(models.IntElement{ID: 1}); !errors.Is(err, ErrEmptyVector) {
+		t.Errorf("InsertInt(nil) = %v, want ErrEmptyVector", err)
+	}
+	if d := built.Distance(models.Element{}, models.Element{Embeddings: []float64{1, 2, 3, 4}}); !math.IsInf(d, 1) {
+		t.Errorf("Distance to an empty vector = %v, want +Inf", d)
+	}
+}
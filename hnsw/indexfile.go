@@ -0,0 +1,180 @@
+package hnsw
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Index files. SaveIndex writes the whole index to one self-describing,
+// versioned file:
+//
+//	"HNSWFILE"           magic
+//	uint32               format version, big-endian
+//	gob fileHeader       parameters, element count and metadata
+//	gob []byte           the checksummed index encoding of Save
+//
+// The header repeats the parameters and the metadata of the index so that
+// ReadIndexInfo can describe a file without decoding the graph; LoadIndex
+// reads them from the index encoding. Vectors are always embedded, also
+// those a VectorStore serves, so a file loads into a complete in-memory
+// index. Of the options, only WithMetric, WithFloat16 and
+// WithKahanSummation are saved; others, such as WithProjection or
+// WithRefineDistance, must be set again after loading.
+//
+// SaveIndex and LoadIndex supersede the per-format helpers
+// (GobStructLocalStore, JsonStructLocalStore, SaveGobGzip, ...), which
+// remain for files written by them.
+
+// indexFileMagic starts every file written by SaveIndex.
+var indexFileMagic = []byte("HNSWFILE")
+
+// indexFileVersion is the format version SaveIndex writes. LoadIndex reads
+// files up to this version.
+const indexFileVersion = 1
+
+// IndexInfo describes an index file; see ReadIndexInfo.
+type IndexInfo struct {
+	Version         int // Format version of the file
+	M               int
+	EfConstruction  int
+	DefaultEf       int
+	NormalizationML float64
+	MaxLayers       int
+	Metric          Metric // L2 for an index with the default metric
+	Dim             int
+	Len             int               // Live elements
+	Metadata        map[string]string // User metadata; nil if none
+}
+
+// fileHeader is the gob form of the header of an index file.
+type fileHeader struct {
+	M               int
+	EfConstruction  int
+	DefaultEf       int
+	NormalizationML float64
+	MaxLayers       int
+	Metric          Metric
+	Dim             int
+	Len             int
+	Metadata        []gobMeta
+}
+
+// SaveIndex writes the index to path in the index file format, atomically
+// replacing any previous file.
+func (h *HNSW) SaveIndex(path string) error {
+	data, err := h.encode(true)
+	if err != nil {
+		return err
+	}
+	hdr := fileHeader{
+		M:               h.M,
+		EfConstruction:  h.EfConstruction,
+		DefaultEf:       h.DefaultEf,
+		NormalizationML: h.NormalizationML,
+		MaxLayers:       h.MaxLayers,
+		Metric:          h.metric(),
+		Dim:             h.Dim,
+		Len:             h.Len(),
+		Metadata:        sortedMeta(h.Metadata),
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		bw.Write(indexFileMagic)
+		binary.Write(bw, binary.BigEndian, uint32(indexFileVersion))
+		enc := gob.NewEncoder(bw)
+		if err := enc.Encode(&hdr); err != nil {
+			return err
+		}
+		if err := enc.Encode(data); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+// LoadIndex reads an index written by SaveIndex and rebuilds its derived
+// state, returning a fully functional index. It fails with ErrCorrupt if
+// the file is not an index file or its checksum does not match, and with
+// an error if the file was written by a newer format version.
+func LoadIndex(path string) (*HNSW, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dec, _, err := readIndexHeader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var data []byte
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var res = HNSW{}
+	if err := res.GobDecode(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	res.restore()
+	return &res, nil
+}
+
+// ReadIndexInfo returns the header of an index file written by SaveIndex
+// without loading the index.
+func ReadIndexInfo(path string) (IndexInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return IndexInfo{}, err
+	}
+	defer file.Close()
+
+	_, info, err := readIndexHeader(bufio.NewReader(file))
+	if err != nil {
+		return IndexInfo{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return info, nil
+}
+
+// readIndexHeader reads the magic, version and header of an index file
+// from r and returns the decoder positioned at the index encoding.
+func readIndexHeader(r io.Reader) (*gob.Decoder, IndexInfo, error) {
+	magic := make([]byte, len(indexFileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, indexFileMagic) {
+		return nil, IndexInfo{}, fmt.Errorf("hnsw: not an index file: %w", ErrCorrupt)
+	}
+	var version uint32
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return nil, IndexInfo{}, fmt.Errorf("hnsw: truncated index file: %w", ErrCorrupt)
+	}
+	if version == 0 || version > indexFileVersion {
+		return nil, IndexInfo{}, fmt.Errorf("hnsw: index file version %d, supported up to %d", version, indexFileVersion)
+	}
+	dec := gob.NewDecoder(r)
+	var hdr fileHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return nil, IndexInfo{}, err
+	}
+	info := IndexInfo{
+		Version:         int(version),
+		M:               hdr.M,
+		EfConstruction:  hdr.EfConstruction,
+		DefaultEf:       hdr.DefaultEf,
+		NormalizationML: hdr.NormalizationML,
+		MaxLayers:       hdr.MaxLayers,
+		Metric:          hdr.Metric,
+		Dim:             hdr.Dim,
+		Len:             hdr.Len,
+	}
+	if len(hdr.Metadata) > 0 {
+		info.Metadata = make(map[string]string, len(hdr.Metadata))
+		for _, m := range hdr.Metadata {
+			info.Metadata[m.Key] = m.Value
+		}
+	}
+	return dec, info, nil
+}
@@ -0,0 +1,53 @@
+package hnsw
+
+import (
+	"fmt"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Integer vectors
+//
+// An index created WithMetric(IntL2) or WithMetric(IntL1) stores []int32
+// vectors in IntVectors instead of Element.Embeddings and accumulates
+// distances in int64, converting to float64 only for the result. The graph
+// code is shared with float indexes: it reaches vectors through the metric,
+// which looks integer vectors up by element ID. Elements of an integer index
+// keep their Msg and GroupID but have no Embeddings, so float-only features
+// such as ExactMatch and EvictFarthestFromCentroid do not apply to it.
+
+// InsertInt adds an integer element. The index must use an integer metric.
+func (h *HNSW) InsertInt(q models.IntElement) error {
+	if !h.Metric.isInt() {
+		return fmt.Errorf("hnsw: InsertInt needs an integer metric, index uses %q", h.Metric)
+	}
+	if len(q.Embeddings) == 0 {
+		return fmt.Errorf("element %d: %w", q.ID, ErrEmptyVector)
+	}
+	if h.Dim > 0 && len(q.Embeddings) != h.Dim {
+		return fmt.Errorf("element %d: dimension %d, index has %d: %w", q.ID, len(q.Embeddings), h.Dim, ErrDimensionMismatch)
+	}
+	if h.Deleted[q.ID] {
+		// Purge the old node before its ID is given the new vector.
+		h.unlinkNodes(map[int]bool{q.ID: true})
+	}
+	if h.Dim == 0 {
+		h.Dim = len(q.Embeddings)
+	}
+	h.IntVectors[q.ID] = q.Embeddings
+	level := h.generateLevel()
+	if level > h.MaxLayers {
+		level = h.MaxLayers
+	}
+	h.insertAtLevel(models.Element{ID: q.ID, Msg: q.Msg, GroupID: q.GroupID, Payload: q.Payload, CreatedAt: q.CreatedAt}, level)
+	return nil
+}
+
+// KNNSearchInt finds the K approximate nearest neighbors of the integer
+// query q, with the same ef and ordering rules as KNNSearchWithEf.
+func (h *HNSW) KNNSearchInt(q models.IntElement, K, ef int) []int {
+	opts := &layerSearch{dist: func(id int) float64 {
+		return h.intDistance(q.Embeddings, h.IntVectors[id])
+	}}
+	return candidateIDs(h.searchKNN(models.Element{ID: q.ID}, K, ef, opts))
+}
@@ -0,0 +1,125 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// ErrDuplicateLabel is returned when a label is already in use.
+var ErrDuplicateLabel = errors.New("hnsw: duplicate label")
+
+// Labels and internal IDs
+//
+// Every node in the graph is keyed by its internal ID, which is the
+// Element.ID it was inserted with. Insert lets the caller choose that ID.
+// InsertLabeled instead takes an external label, as hnswlib does, and
+// assigns the internal ID itself. Searches always return internal IDs; use
+// Label to translate them back.
+//
+// A label maps to at most one live element. Deleting the element frees the
+// label, so it can be inserted again under a new internal ID. Elements added
+// with Insert have no label.
+//
+// Deleting a labeled element also frees its internal ID. InsertLabeled
+// reuses freed IDs, the most recently freed first, before it counts up
+// from 0 past the IDs of stored elements. Reusing the ID of a tombstone
+// removes the old node from the graph as Compact would, so under churn the
+// internal IDs, and the tombstones kept in memory, stay bounded by the peak
+// number of labeled elements without ever running Compact. The removal
+// scans every connection of the index, as Compact does for any number of
+// nodes at once, so reuse trades insert time for bounded memory; batching
+// deletes before a Compact is cheaper when memory allows. IDs chosen by
+// the caller through Insert are never reused.
+
+// InsertLabeled adds vec under the external label and returns the internal
+// ID assigned to it, a freed one if any. It fails with ErrDuplicateLabel if
+// the label is already in use by a live element.
+func (h *HNSW) InsertLabeled(label int, vec []float64, msg string) (int, error) {
+	if _, ok := h.InternalID(label); ok {
+		return 0, fmt.Errorf("label %d: %w", label, ErrDuplicateLabel)
+	}
+	id, reused := h.nextFreeID()
+	if err := h.Insert(models.Element{ID: id, Embeddings: vec, Msg: msg}); err != nil {
+		return 0, err
+	}
+	if reused {
+		h.freeIDs = h.freeIDs[:len(h.freeIDs)-1]
+	}
+	h.Labels[id] = label
+	h.labelIDs[label] = id
+	return id, nil
+}
+
+// nextFreeID returns the internal ID InsertLabeled assigns next and whether
+// it is the last entry of freeIDs. Entries that were inserted again
+// through Insert since they were freed are dropped.
+func (h *HNSW) nextFreeID() (int, bool) {
+	for len(h.freeIDs) > 0 {
+		id := h.freeIDs[len(h.freeIDs)-1]
+		if _, ok := h.Elements[id]; !ok || h.Deleted[id] {
+			return id, true
+		}
+		h.freeIDs = h.freeIDs[:len(h.freeIDs)-1]
+	}
+	for {
+		if _, ok := h.Elements[h.nextID]; !ok {
+			return h.nextID, false
+		}
+		h.nextID++
+	}
+}
+
+// InternalID returns the internal ID of the live element with the given
+// label.
+func (h *HNSW) InternalID(label int) (int, bool) {
+	id, ok := h.labelIDs[label]
+	if !ok || h.Deleted[id] {
+		return 0, false
+	}
+	return id, true
+}
+
+// Label returns the external label of the live element with internal ID id.
+// It reports false for deleted elements and for elements added with Insert.
+func (h *HNSW) Label(id int) (int, bool) {
+	label, ok := h.Labels[id]
+	if !ok || h.Deleted[id] {
+		return 0, false
+	}
+	return label, true
+}
+
+// unlabel drops the label of a node that is being removed from the graph.
+func (h *HNSW) unlabel(id int) {
+	label, ok := h.Labels[id]
+	if !ok {
+		return
+	}
+	delete(h.Labels, id)
+	if h.labelIDs[label] == id {
+		delete(h.labelIDs, label)
+	}
+}
+
+// rebuildLabelIDs derives the label to internal ID map from Labels. When a
+// label was reused, the live element wins over deleted ones. The IDs of
+// deleted labeled elements become the free IDs, the lowest reused first.
+func (h *HNSW) rebuildLabelIDs() {
+	h.labelIDs = make(map[int]int, len(h.Labels))
+	h.freeIDs = h.freeIDs[:0]
+	ids := sortedKeys(h.Labels)
+	for i := len(ids) - 1; i >= 0; i-- {
+		if h.Deleted[ids[i]] {
+			h.freeIDs = append(h.freeIDs, ids[i])
+		}
+	}
+	for _, id := range ids {
+		label := h.Labels[id]
+		if prev, ok := h.labelIDs[label]; ok && !h.Deleted[prev] {
+			continue
+		}
+		h.labelIDs[label] = id
+	}
+}
@@ -0,0 +1,58 @@
+package hnsw
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestInsertLabeledChurn replaces random labeled elements through Delete
+// and InsertLabeled many times over and checks that the stored elements,
+// the internal ID range and the bookkeeping of the index stay bounded.
+func TestInsertLabeledChurn(t *testing.T) {
+	const n, d = 300, 4
+	r := rand.New(rand.NewSource(42))
+	h := NewHNSW(50, 8, 5, 0.48, WithSeed(42))
+	for label := 0; label < n; label++ {
+		if _, err := h.InsertLabeled(label, randomVector(r, d), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for cycle := 1; cycle <= 1000; cycle++ {
+		label := r.Intn(n)
+		id, ok := h.InternalID(label)
+		if !ok {
+			t.Fatalf("cycle %d: label %d not found", cycle, label)
+		}
+		if err := h.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.InsertLabeled(label, randomVector(r, d), ""); err != nil {
+			t.Fatal(err)
+		}
+		if cycle%250 != 0 {
+			continue
+		}
+		if h.Len() != n {
+			t.Fatalf("cycle %d: Len = %d, want %d", cycle, h.Len(), n)
+		}
+		for name, size := range map[string]int{
+			"Elements":    len(h.Elements),
+			"Levels":      len(h.Levels),
+			"Labels":      len(h.Labels),
+			"labelIDs":    len(h.labelIDs),
+			"insertOrder": len(h.insertOrder),
+			"layer 0":     len(h.Layers[0]),
+		} {
+			if size > n {
+				t.Errorf("cycle %d: %s holds %d entries, want at most %d", cycle, name, size, n)
+			}
+		}
+		if len(h.Deleted) != 0 || len(h.freeIDs) != 0 {
+			t.Errorf("cycle %d: %d tombstones and %d free IDs left", cycle, len(h.Deleted), len(h.freeIDs))
+		}
+		if top := slices.Max(sortedKeys(h.Elements)); top >= n {
+			t.Errorf("cycle %d: internal ID %d, want all below %d", cycle, top, n)
+		}
+	}
+}
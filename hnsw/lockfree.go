@@ -0,0 +1,303 @@
+package hnsw
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// Lock-free reads. LockFreeHNSW keeps a second, read-only copy of the graph
+// next to the index it wraps, in which every connection list is an
+// immutable slice behind an atomic pointer. Writers take a mutex, update the
+// index as usual and then publish the lists that changed by swapping in new
+// slices, never by editing published ones. Searches take no lock at all:
+// they load the pointers and see each list either before or after a swap,
+// never half written, and so never wait for an insert nor slow it down.
+//
+// Nodes are found through a table indexed by ID, grown by copying, so IDs
+// must not be negative and should be dense, like those InsertLabeled
+// assigns: the table takes 8 bytes per ID up to the largest one.
+//
+// Consistency: a search sees every write that completed before it started,
+// and possibly parts of writes running meanwhile. An insert publishes the
+// new node before the lists that link to it and the entry point last, so a
+// search either reaches the node through a complete list or not at all.
+// Connections to nodes removed meanwhile are skipped.
+//
+// Costs: each write allocates a new slice for every list it changed, about
+// 2*M small slices per insert, and the published nodes take one slice
+// header per layer on top of the index. Vectors are shared with the index
+// unless a VectorStore serves them, in which case they are copied.
+//
+// Searches rank by the index metric only: WithRefineDistance and
+// WithBatchDistance do not apply. Integer metrics are not supported.
+type LockFreeHNSW struct {
+	mu sync.Mutex // Serializes writers
+	h  *HNSW
+
+	nodes     atomic.Pointer[[]atomic.Pointer[lockFreeNode]] // Published nodes by ID
+	entry     atomic.Pointer[lockFreeEntry]                  // Published entry point, nil when empty
+	defaultEf atomic.Int64                                   // DefaultEf of h as last published
+	metric    *HNSW                                          // Distance settings of h, read without the lock
+}
+
+// lockFreeNode is a published node. Only links and deleted change after
+// publication; a new vector or level publishes a new node.
+type lockFreeNode struct {
+	vec     []float64
+	links   []atomic.Pointer[[]int] // Neighbor IDs at each layer up to the node level
+	deleted atomic.Bool             // Deleted or hidden: traversed, never returned
+}
+
+// lockFreeEntry is a published entry point.
+type lockFreeEntry struct {
+	id  int
+	top int // Top layer of the graph
+}
+
+// NewLockFreeHNSW wraps h and publishes its graph. h must not be used
+// directly afterwards. It fails for integer metrics and for indexes with
+// negative IDs.
+func NewLockFreeHNSW(h *HNSW) (*LockFreeHNSW, error) {
+	if h.Metric.isInt() {
+		return nil, errors.New("hnsw: lock-free reads do not support integer metrics")
+	}
+	for id := range h.Elements {
+		if id < 0 {
+			return nil, fmt.Errorf("element %d: hnsw: lock-free reads need non-negative IDs", id)
+		}
+	}
+	l := &LockFreeHNSW{
+		h:      h,
+		metric: &HNSW{Metric: h.Metric, Kahan: h.Kahan},
+	}
+	l.nodes.Store(new([]atomic.Pointer[lockFreeNode]))
+	l.publishAll()
+	return l, nil
+}
+
+// Insert adds a new element into the index and publishes the lists it
+// changed. Replacing an existing element, or evicting one at the
+// WithMaxElements limit, republishes the whole graph.
+func (l *LockFreeHNSW) Insert(q models.Element) error {
+	if q.ID < 0 {
+		return fmt.Errorf("element %d: hnsw: lock-free reads need non-negative IDs", q.ID)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, replaced := l.h.Elements[q.ID]
+	if err := l.h.Insert(q); err != nil {
+		return err
+	}
+	if replaced || l.h.maxElements > 0 {
+		l.publishAll()
+		return nil
+	}
+	// The new node links to every node that linked to it.
+	l.publish(q.ID)
+	for lc := 0; lc <= l.h.Levels[q.ID]; lc++ {
+		for _, c := range l.h.Layers[lc][q.ID].Candidates {
+			l.publish(c.NodeID)
+		}
+	}
+	l.publishEntry()
+	return nil
+}
+
+// Delete marks element id as deleted.
+func (l *LockFreeHNSW) Delete(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.h.Delete(id); err != nil {
+		return err
+	}
+	l.publish(id)
+	l.publishEntry()
+	return nil
+}
+
+// View runs fn with the index under the writer lock. fn must not modify
+// it. Searches keep running meanwhile.
+func (l *LockFreeHNSW) View(fn func(h *HNSW)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn(l.h)
+}
+
+// Update runs fn with the index under the writer lock and then republishes
+// the whole graph, which takes one pass over the elements. fn must not add
+// negative IDs or change the metric.
+func (l *LockFreeHNSW) Update(fn func(h *HNSW)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn(l.h)
+	l.publishAll()
+}
+
+// KNNSearch finds the K approximate nearest neighbors of q without locking.
+func (l *LockFreeHNSW) KNNSearch(q models.Element, K int) []int {
+	return l.KNNSearchWithEf(q, K, max(int(l.defaultEf.Load()), K))
+}
+
+// KNNSearchWithEf finds the K approximate nearest neighbors of q with an
+// explicit search ef without locking. Like HNSW.KNNSearchWithEf, it
+// traverses deleted nodes but only returns live ones.
+func (l *LockFreeHNSW) KNNSearchWithEf(q models.Element, K, ef int) []int {
+	entry := l.entry.Load()
+	if entry == nil {
+		return nil
+	}
+	nodes := *l.nodes.Load()
+	ep := entry.id
+	for lc := entry.top; lc >= 1; lc-- {
+		if W := l.searchLayer(nodes, q.Embeddings, ep, 1, lc, false); len(W) > 0 {
+			ep = nearest(W)
+		}
+	}
+	res := l.searchLayer(nodes, q.Embeddings, ep, max(ef, K), 0, true)
+	sortCandidates(res)
+	return candidateIDs(res[:min(len(res), K)])
+}
+
+// searchLayer is HNSW.searchLayer over the published nodes, returning the
+// ef nearest nodes found at layer lc. With live, deleted nodes are
+// traversed but left out of the result.
+func (l *LockFreeHNSW) searchLayer(nodes []atomic.Pointer[lockFreeNode], q []float64, entryPoint, ef, lc int, live bool) []models.Candidate {
+	first := lookupNode(nodes, entryPoint)
+	if first == nil || len(first.links) <= lc {
+		return nil
+	}
+	ep := models.Candidate{NodeID: entryPoint, Distance: l.metric.vectorDistance(q, first.vec)}
+	V := map[int]bool{entryPoint: true}
+	C := hnswheap.NewSmallCandidatesHeap()
+	heap.Push(C, ep)
+	W := hnswheap.NewBigCandidatesHeap()
+	if !live || !first.deleted.Load() {
+		heap.Push(W, ep)
+	}
+	for C.Len() > 0 {
+		nc := heap.Pop(C).(models.Candidate)
+		if W.Len() >= ef && nc.Distance > W.Candidates[0].Distance {
+			break
+		}
+		// The node may have been removed or replaced since it was reached.
+		n := lookupNode(nodes, nc.NodeID)
+		if n == nil || len(n.links) <= lc {
+			continue
+		}
+		for _, id := range *n.links[lc].Load() {
+			if V[id] {
+				continue
+			}
+			V[id] = true
+			// Skip nodes removed, or not yet published when nodes was loaded.
+			e := lookupNode(nodes, id)
+			if e == nil || len(e.links) <= lc {
+				continue
+			}
+			c := models.Candidate{NodeID: id, Distance: l.metric.vectorDistance(q, e.vec)}
+			if W.Len() < ef || c.Distance < W.Candidates[0].Distance {
+				heap.Push(C, c)
+				if live && e.deleted.Load() {
+					continue
+				}
+				heap.Push(W, c)
+				if W.Len() > ef {
+					heap.Pop(W)
+				}
+			}
+		}
+	}
+	return W.Candidates
+}
+
+// lookupNode returns the published node id, or nil.
+func lookupNode(nodes []atomic.Pointer[lockFreeNode], id int) *lockFreeNode {
+	if id < 0 || id >= len(nodes) {
+		return nil
+	}
+	return nodes[id].Load()
+}
+
+// publish publishes the current state of node id, or its removal.
+func (l *LockFreeHNSW) publish(id int) {
+	nodes := *l.nodes.Load()
+	level, ok := l.h.Levels[id]
+	if !ok {
+		if id < len(nodes) {
+			nodes[id].Store(nil)
+		}
+		return
+	}
+	if id >= len(nodes) {
+		nodes = l.grow(id + 1)
+	}
+	vec := l.h.vector(id)
+	n := nodes[id].Load()
+	if n == nil || len(n.links) != level+1 || !slices.Equal(n.vec, vec) {
+		if l.h.vectorStore != nil {
+			vec = append([]float64(nil), vec...)
+		}
+		n = &lockFreeNode{vec: vec, links: make([]atomic.Pointer[[]int], level+1)}
+		l.publishLinks(id, n)
+		nodes[id].Store(n)
+		return
+	}
+	l.publishLinks(id, n)
+}
+
+// publishLinks stores fresh copies of the connection lists and the deleted
+// flag of node id in n.
+func (l *LockFreeHNSW) publishLinks(id int, n *lockFreeNode) {
+	for lc := range n.links {
+		var links []int
+		if conns, ok := l.h.Layers[lc][id]; ok {
+			links = candidateIDs(conns.Candidates)
+		}
+		n.links[lc].Store(&links)
+	}
+	n.deleted.Store(l.h.Deleted[id] || l.h.Hidden[id])
+}
+
+// grow replaces the node table with one of at least size slots and returns
+// it. Searches holding the old table keep a valid, if older, view.
+func (l *LockFreeHNSW) grow(size int) []atomic.Pointer[lockFreeNode] {
+	old := *l.nodes.Load()
+	nodes := make([]atomic.Pointer[lockFreeNode], max(size, 2*len(old), 1024))
+	for i := range old {
+		nodes[i].Store(old[i].Load())
+	}
+	l.nodes.Store(&nodes)
+	return nodes
+}
+
+// publishEntry publishes the entry point and top layer of the index.
+func (l *LockFreeHNSW) publishEntry() {
+	if l.h.EnterPoint < 0 || len(l.h.Layers) == 0 {
+		l.entry.Store(nil)
+		return
+	}
+	l.entry.Store(&lockFreeEntry{id: l.h.EnterPoint, top: len(l.h.Layers) - 1})
+}
+
+// publishAll publishes every node of the index and removes the nodes it no
+// longer has.
+func (l *LockFreeHNSW) publishAll() {
+	nodes := *l.nodes.Load()
+	for id := range nodes {
+		if _, ok := l.h.Levels[id]; !ok {
+			nodes[id].Store(nil)
+		}
+	}
+	for _, id := range sortedKeys(l.h.Levels) {
+		l.publish(id)
+	}
+	l.publishEntry()
+	l.defaultEf.Store(int64(l.h.DefaultEf))
+}
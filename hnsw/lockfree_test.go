@@ -0,0 +1,154 @@
+package hnsw
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// checkPublished fails unless the published graph of l matches its index.
+func checkPublished(t *testing.T, l *LockFreeHNSW) {
+	t.Helper()
+	nodes := *l.nodes.Load()
+	h := l.h
+	for id := range nodes {
+		n := nodes[id].Load()
+		level, ok := h.Levels[id]
+		if !ok {
+			if n != nil {
+				t.Fatalf("node %d: published but not in the index", id)
+			}
+			continue
+		}
+		if n == nil || len(n.links) != level+1 {
+			t.Fatalf("node %d: not published at level %d", id, level)
+		}
+		for lc := range n.links {
+			if got, want := *n.links[lc].Load(), candidateIDs(h.Layers[lc][id].Candidates); !slices.Equal(got, want) {
+				t.Fatalf("node %d layer %d: published %v, index has %v", id, lc, got, want)
+			}
+		}
+		if n.deleted.Load() != (h.Deleted[id] || h.Hidden[id]) {
+			t.Fatalf("node %d: published deleted flag %v", id, n.deleted.Load())
+		}
+		if !slices.Equal(n.vec, h.vector(id)) {
+			t.Fatalf("node %d: published vector differs", id)
+		}
+	}
+	for id := range h.Levels {
+		if id >= len(nodes) {
+			t.Fatalf("node %d: beyond the node table", id)
+		}
+	}
+	if e := l.entry.Load(); e == nil || e.id != h.EnterPoint || e.top != len(h.Layers)-1 {
+		t.Fatalf("published entry %+v, index has %d with %d layers", e, h.EnterPoint, len(h.Layers))
+	}
+}
+
+// TestLockFreeConcurrent runs searches while two writers insert, delete,
+// replace and compact. Run it with -race.
+func TestLockFreeConcurrent(t *testing.T) {
+	const d, K = 8, 10
+	n := 1500
+	if testing.Short() {
+		n = 600
+	}
+	r := rand.New(rand.NewSource(1))
+	h := NewHNSW(40, 8, 5, 0.48, WithSeed(2))
+	for i := 0; i < 200; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := NewLockFreeHNSW(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stop atomic.Bool
+	var readers sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		readers.Add(1)
+		go func(g int) {
+			defer readers.Done()
+			rr := rand.New(rand.NewSource(int64(g)))
+			for !stop.Load() {
+				q := models.Element{Embeddings: randomVector(rr, d)}
+				res := l.KNNSearch(q, K)
+				if g%2 == 1 {
+					res = l.KNNSearchWithEf(q, K, 40)
+				}
+				if len(res) > K {
+					t.Errorf("got %d results, want at most %d", len(res), K)
+				}
+				seen := make(map[int]bool)
+				for _, id := range res {
+					if seen[id] {
+						t.Errorf("result %v repeats %d", res, id)
+					}
+					seen[id] = true
+				}
+			}
+		}(g)
+	}
+
+	var writers sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			rr := rand.New(rand.NewSource(int64(100 + w)))
+			for i := 200 + w; i < n; i += 2 {
+				if err := l.Insert(models.Element{ID: i, Embeddings: randomVector(rr, d)}); err != nil {
+					t.Errorf("Insert(%d): %v", i, err)
+				}
+				if i%5 == 0 {
+					l.Delete(rr.Intn(i)) // May hit an ID already deleted
+				}
+				if i%97 == 0 {
+					l.Insert(models.Element{ID: rr.Intn(i), Embeddings: randomVector(rr, d)})
+				}
+				if i%1001 == 0 {
+					l.Update(func(h *HNSW) { h.Compact() })
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	stop.Store(true)
+	readers.Wait()
+	checkPublished(t, l)
+
+	// With writes done, searches see the final index.
+	for i := 0; i < 20; i++ {
+		q := models.Element{Embeddings: randomVector(r, d)}
+		res := l.KNNSearch(q, K)
+		if len(res) != K {
+			t.Fatalf("got %d results, want %d", len(res), K)
+		}
+		l.View(func(h *HNSW) {
+			for _, id := range res {
+				if _, ok := h.Elements[id]; !ok || h.Deleted[id] {
+					t.Errorf("result %d is not a live element", id)
+				}
+			}
+		})
+	}
+}
+
+func TestLockFreeEmpty(t *testing.T) {
+	l, err := NewLockFreeHNSW(NewHNSW(10, 4, 3, 0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := l.KNNSearch(models.Element{Embeddings: []float64{1}}, 3); len(res) != 0 {
+		t.Errorf("KNNSearch on an empty index = %v, want none", res)
+	}
+	if err := l.Insert(models.Element{ID: -1, Embeddings: []float64{1}}); err == nil {
+		t.Error("Insert with a negative ID succeeded")
+	}
+}
@@ -0,0 +1,71 @@
+package hnsw
+
+import (
+	"errors"
+	"time"
+)
+
+// maintenance is a running StartMaintenance loop.
+type maintenance struct {
+	stop chan struct{} // Closed to stop the loop
+	done chan struct{} // Closed when the loop has returned
+}
+
+// StartMaintenance runs ops every interval in a background goroutine, each
+// tick under the exclusive lock, so searches and inserts wait while a
+// tick runs. ops are maintenance operations such as
+//
+//	func(h *HNSW) { h.Compact() }
+//	func(h *HNSW) { h.CapDegrees(h.M) }
+//
+// and run in the order given. Only one loop runs at a time; StartMaintenance
+// fails if one is already running. Stop it with StopMaintenance, e.g. to
+// pause maintenance during a bulk load, and start it again to resume.
+func (s *SafeHNSW) StartMaintenance(interval time.Duration, ops ...func(h *HNSW)) error {
+	if interval <= 0 {
+		return errors.New("hnsw: maintenance interval must be positive")
+	}
+	s.maintMu.Lock()
+	defer s.maintMu.Unlock()
+	if s.maint != nil {
+		return errors.New("hnsw: maintenance already running")
+	}
+	m := &maintenance{stop: make(chan struct{}), done: make(chan struct{})}
+	s.maint = m
+	go s.maintain(m, interval, ops)
+	return nil
+}
+
+// StopMaintenance stops the loop started by StartMaintenance and waits for
+// it to exit, letting a tick in progress finish. It does nothing if no loop
+// is running.
+func (s *SafeHNSW) StopMaintenance() {
+	s.maintMu.Lock()
+	m := s.maint
+	s.maint = nil
+	s.maintMu.Unlock()
+	if m == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+}
+
+// maintain is the loop of StartMaintenance.
+func (s *SafeHNSW) maintain(m *maintenance, interval time.Duration, ops []func(h *HNSW)) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			s.Update(func(h *HNSW) {
+				for _, op := range ops {
+					op(h)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// KNNSearchMasked finds the K approximate nearest neighbors of a partial
+// query: mask[i] reports whether q[i] is known, and unknown dimensions are
+// left out of every distance. q and mask must have the index dimension.
+//
+// Sums over the known dimensions are scaled by dim/known, so for L2 and
+// InnerProduct distances stay comparable in magnitude to full-vector ones.
+// For Cosine and Angular both vectors are restricted to the known
+// dimensions, which needs no scaling. Integer indexes are not supported.
+func (h *HNSW) KNNSearchMasked(q []float64, mask []bool, K, ef int) ([]int, error) {
+	if h.Metric.isInt() {
+		return nil, fmt.Errorf("hnsw: masked search does not support integer metric %q", h.Metric)
+	}
+	if len(q) != h.Dim || len(mask) != h.Dim {
+		return nil, fmt.Errorf("hnsw: query dimension %d, mask %d, index has %d: %w", len(q), len(mask), h.Dim, ErrDimensionMismatch)
+	}
+	known := make([]int, 0, len(mask))
+	for i, ok := range mask {
+		if ok {
+			known = append(known, i)
+		}
+	}
+	if len(known) == 0 {
+		return nil, errors.New("hnsw: mask selects no dimension")
+	}
+	opts := &layerSearch{dist: h.maskedDistance(q, known)}
+	return candidateIDs(h.searchKNN(models.Element{Embeddings: q}, K, ef, opts)), nil
+}
+
+// maskedDistance returns the distance from q to stored elements over the
+// dimensions in known only.
+func (h *HNSW) maskedDistance(q []float64, known []int) func(id int) float64 {
+	scale := float64(len(q)) / float64(len(known))
+	switch h.Metric {
+	case Cosine, Angular:
+		qn := 0.0
+		for _, i := range known {
+			qn += q[i] * q[i]
+		}
+		qn = math.Sqrt(qn)
+		return func(id int) float64 {
+			v := h.vector(id)
+			dot, vn := 0.0, 0.0
+			for _, i := range known {
+				dot += q[i] * v[i]
+				vn += v[i] * v[i]
+			}
+			if qn == 0 || vn == 0 {
+				return h.angle(1)
+			}
+			return h.angle(1 - dot/(qn*math.Sqrt(vn)))
+		}
+	case InnerProduct:
+		return func(id int) float64 {
+			v := h.vector(id)
+			dot := 0.0
+			for _, i := range known {
+				dot += q[i] * v[i]
+			}
+			return -dot * scale
+		}
+	default:
+		return func(id int) float64 {
+			v := h.vector(id)
+			sum := 0.0
+			for _, i := range known {
+				diff := q[i] - v[i]
+				sum += diff * diff
+			}
+			return math.Sqrt(sum * scale)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDuplicateID is returned when an element ID is already in the index.
+var ErrDuplicateID = errors.New("hnsw: duplicate element ID")
+
+// Merge inserts every live element of other into h, reusing other's vectors.
+// If an ID exists in both indexes Merge fails before changing anything,
+// unless overwrite is set, in which case the existing element is removed
+// and replaced by other's. Elements are inserted in ascending ID order.
+// Every element is checked as Insert would check it, after projection,
+// before h is changed, so an element Insert would reject fails the merge
+// with h left as it was.
+func (h *HNSW) Merge(other *HNSW, overwrite bool) error {
+	if other == h {
+		return errors.New("hnsw: cannot merge an index into itself")
+	}
+	if h.project == nil && h.Dim > 0 && other.Dim > 0 && other.Dim != h.Dim {
+		return fmt.Errorf("hnsw: merging dimension %d into %d: %w", other.Dim, h.Dim, ErrDimensionMismatch)
+	}
+	var ids []int
+	for _, id := range sortedKeys(other.Elements) {
+		if !other.Deleted[id] {
+			ids = append(ids, id)
+		}
+	}
+	dim := h.Dim
+	for _, id := range ids {
+		e, err := h.prepare(other.element(id))
+		if err != nil {
+			return err
+		}
+		if dim == 0 {
+			dim = len(e.Embeddings)
+		} else if len(e.Embeddings) != dim {
+			return fmt.Errorf("element %d: dimension %d, index has %d: %w", id, len(e.Embeddings), dim, ErrDimensionMismatch)
+		}
+	}
+	collisions := make(map[int]bool)
+	for _, id := range ids {
+		if _, ok := h.Get(id); ok {
+			if !overwrite {
+				return fmt.Errorf("element %d: %w", id, ErrDuplicateID)
+			}
+			collisions[id] = true
+		}
+	}
+	if len(collisions) > 0 {
+		h.unlinkNodes(collisions)
+	}
+	for _, id := range ids {
+		if err := h.Insert(other.element(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package hnsw
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+func TestMergeRecall(t *testing.T) {
+	const n, d, K, ef = 1000, 16, 10, 20
+	r := rand.New(rand.NewSource(12))
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		vectors[i] = randomVector(r, d)
+	}
+	single := NewHNSW(50, 8, 5, 0.48, WithSeed(1))
+	halves := [2]*HNSW{NewHNSW(50, 8, 5, 0.48, WithSeed(2)), NewHNSW(50, 8, 5, 0.48, WithSeed(3))}
+	for i, v := range vectors {
+		e := models.Element{ID: i, Embeddings: v}
+		if err := single.Insert(e); err != nil {
+			t.Fatal(err)
+		}
+		if err := halves[i%2].Insert(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merged := halves[0]
+	if err := merged.Merge(halves[1], false); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	if merged.Len() != n {
+		t.Fatalf("merged Len = %d, want %d", merged.Len(), n)
+	}
+	if err := merged.HealthCheck(); err != nil {
+		t.Fatalf("merged index: %v", err)
+	}
+
+	queries := make([]models.Element, 100)
+	truth := make([][]int, len(queries))
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, d)}
+		truth[i] = single.BruteForceKNN(queries[i], K)
+	}
+	want := single.EvaluateRecall(queries, truth, K, ef)
+	if got := merged.EvaluateRecall(queries, truth, K, ef); got < want-0.05 {
+		t.Errorf("merged recall %.3f, single build %.3f", got, want)
+	}
+}
+
+func TestMergeDuplicateID(t *testing.T) {
+	a, b := buildRandom(t, 10, 4, 13), buildRandom(t, 10, 4, 14)
+	before := a.Len()
+	if err := a.Merge(b, false); !errors.Is(err, ErrDuplicateID) {
+		t.Fatalf("Merge error %v, want ErrDuplicateID", err)
+	}
+	if a.Len() != before {
+		t.Errorf("failed Merge changed Len from %d to %d", before, a.Len())
+	}
+	if err := a.Merge(b, true); err != nil {
+		t.Fatalf("Merge with overwrite: %v", err)
+	}
+	for id, e := range b.Elements {
+		if got, _ := a.Get(id); got.Embeddings[0] != e.Embeddings[0] {
+			t.Errorf("element %d not replaced by the merged one", id)
+		}
+	}
+}
+
+// TestMergeInvalidElement merges indexes holding an element h would reject,
+// with overwrite set and colliding IDs, and checks that h is unchanged.
+func TestMergeInvalidElement(t *testing.T) {
+	// As in an index file edited by hand, the last element to merge has
+	// lost its vector.
+	noVector := buildRandom(t, 100, 4, 45)
+	noVector.Elements[99] = models.Element{ID: 99}
+	identity := func(v []float64) []float64 { return v }
+	for _, tc := range []struct {
+		name  string
+		h     *HNSW
+		other *HNSW
+		want  error
+	}{
+		{"no vector", buildRandom(t, 50, 4, 44), noVector, ErrEmptyVector},
+		// The projection hides the dimension of other from the up-front
+		// check, so only the projected vectors show the mismatch.
+		{"projected dimension", buildRandom(t, 50, 4, 46, WithProjection(identity, 4)), buildRandom(t, 100, 5, 47), ErrDimensionMismatch},
+	} {
+		before, err := tc.h.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.h.Merge(tc.other, true); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: Merge error %v, want %v", tc.name, err, tc.want)
+		}
+		after, err := tc.h.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Errorf("%s: failed Merge changed the index", tc.name)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package hnsw
+
+import (
+	"math"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Metric names the distance function of an index.
+type Metric string
+
+const (
+	L2           Metric = "l2"      // Euclidean distance
+	Cosine       Metric = "cosine"  // 1 - cosine similarity, in [0, 2]
+	Angular      Metric = "angular" // Angle between vectors, acos of cosine similarity, in [0, π]
+	InnerProduct Metric = "ip"      // Negative dot product
+	IntL2        Metric = "int_l2"  // Euclidean distance over integer vectors
+	IntL1        Metric = "int_l1"  // Manhattan distance over integer vectors
+)
+
+// isInt reports whether m works on the integer vectors of IntElements.
+func (m Metric) isInt() bool {
+	return m == IntL2 || m == IntL1
+}
+
+// usesNorms reports whether m is computed from the cosine similarity, for
+// which the vector norms are cached.
+func (m Metric) usesNorms() bool {
+	return m == Cosine || m == Angular
+}
+
+// Score maps a distance d under m to a similarity in [0, 1] that decreases
+// with d:
+//
+//	L2, IntL2, IntL1  1 / (1 + d)
+//	Cosine            (1 + cos) / 2 = 1 - d/2, clamped to [0, 1]
+//	Angular           1 - d/π
+//	InnerProduct      1 / (1 + exp(d)), the logistic function of the dot product
+//
+// Scores order results like distances but are not calibrated
+// probabilities; compare them within one index.
+func (m Metric) Score(d float64) float64 {
+	switch m {
+	case Cosine:
+		return math.Max(0, math.Min(1, 1-d/2))
+	case Angular:
+		return math.Max(0, math.Min(1, 1-d/math.Pi))
+	case InnerProduct:
+		return 1 / (1 + math.Exp(d))
+	default:
+		return 1 / (1 + d)
+	}
+}
+
+// DistanceFunc computes the distance between two elements.
+type DistanceFunc func(a, b models.Element) float64
+
+// refineQuery returns the refine distance from q to stored elements by ID.
+func (h *HNSW) refineQuery(q models.Element) func(id int) float64 {
+	return func(id int) float64 {
+		return h.refine(q, h.element(id))
+	}
+}
+
+// squaredL2 returns the squared Euclidean distance between a and b.
+func squaredL2(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		diff := a[i] - b[i]
+		sum += diff * diff
+	}
+	return sum
+}
+
+// The compensated variants below carry the low-order bits lost by each
+// addition in a second accumulator (Neumaier's form of Kahan summation),
+// so the result is as accurate as if summed in twice the precision. They
+// cost about four floating-point operations per term instead of one.
+
+// kahanAdd adds x to the compensated sum (sum, c).
+func kahanAdd(sum, c, x float64) (float64, float64) {
+	t := sum + x
+	if math.Abs(sum) >= math.Abs(x) {
+		c += (sum - t) + x
+	} else {
+		c += (x - t) + sum
+	}
+	return t, c
+}
+
+// kahanSquaredL2 is squaredL2 with compensated summation.
+func kahanSquaredL2(a, b []float64) float64 {
+	sum, c := 0.0, 0.0
+	for i := range a {
+		diff := a[i] - b[i]
+		sum, c = kahanAdd(sum, c, diff*diff)
+	}
+	return sum + c
+}
+
+// kahanDot is dot with compensated summation.
+func kahanDot(a, b []float64) float64 {
+	sum, c := 0.0, 0.0
+	for i := range a {
+		sum, c = kahanAdd(sum, c, a[i]*b[i])
+	}
+	return sum + c
+}
+
+// squaredL2 returns squaredL2(a, b), compensated if h.Kahan is set.
+func (h *HNSW) squaredL2(a, b []float64) float64 {
+	if h.Kahan {
+		return kahanSquaredL2(a, b)
+	}
+	return squaredL2(a, b)
+}
+
+// dot returns dot(a, b), compensated if h.Kahan is set.
+func (h *HNSW) dot(a, b []float64) float64 {
+	if h.Kahan {
+		return kahanDot(a, b)
+	}
+	return dot(a, b)
+}
+
+// norm returns norm(a), compensated if h.Kahan is set.
+func (h *HNSW) norm(a []float64) float64 {
+	return math.Sqrt(h.dot(a, a))
+}
+
+// intSquaredL2 returns the squared Euclidean distance between a and b,
+// accumulated in int64.
+func intSquaredL2(a, b []int32) int64 {
+	var sum int64
+	for i := range a {
+		diff := int64(a[i]) - int64(b[i])
+		sum += diff * diff
+	}
+	return sum
+}
+
+// intL1 returns the Manhattan distance between a and b, accumulated in int64.
+func intL1(a, b []int32) int64 {
+	var sum int64
+	for i := range a {
+		diff := int64(a[i]) - int64(b[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		sum += diff
+	}
+	return sum
+}
+
+// intDistance returns the distance between integer vectors under the index
+// metric, +Inf if either is empty. Only the final result is converted to
+// float64.
+func (h *HNSW) intDistance(a, b []int32) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return math.Inf(1)
+	}
+	if h.Metric == IntL1 {
+		return float64(intL1(a, b))
+	}
+	return math.Sqrt(float64(intSquaredL2(a, b)))
+}
+
+// dot returns the dot product of a and b.
+func dot(a, b []float64) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// norm returns the Euclidean length of a.
+func norm(a []float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+// cosineDistance returns 1 - cos(a, b). A zero vector is treated as
+// orthogonal to everything.
+func (h *HNSW) cosineDistance(a, b []float64) float64 {
+	return h.cosineWithNorms(a, b, h.norm(a), h.norm(b))
+}
+
+// angle converts the cosine distance d to the index metric: Angular takes
+// the angle acos(1 - d), clamping the cosine to [-1, 1] first since
+// rounding can push it just past 1 for (anti)parallel vectors, while the
+// other metrics keep d.
+func (h *HNSW) angle(d float64) float64 {
+	if h.Metric != Angular {
+		return d
+	}
+	return math.Acos(math.Max(-1, math.Min(1, 1-d)))
+}
+
+// cosineWithNorms is cosineDistance with precomputed norms na and nb.
+func (h *HNSW) cosineWithNorms(a, b []float64, na, nb float64) float64 {
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - h.dot(a, b)/(na*nb)
+}
+
+// queryDistance returns a function giving the distance from q to stored
+// elements by ID. For Cosine and Angular the norm of q is computed once and
+// the cached norms of stored elements are used.
+func (h *HNSW) queryDistance(q models.Element) func(id int) float64 {
+	if h.halfStored() {
+		return h.halfQueryDistance(q)
+	}
+	switch {
+	case h.Metric.isInt():
+		return func(id int) float64 {
+			return h.Distance(q, h.Elements[id])
+		}
+	case h.Metric.usesNorms():
+		qn := h.norm(q.Embeddings)
+		return func(id int) float64 {
+			return h.angle(h.cosineWithNorms(q.Embeddings, h.vector(id), qn, h.nodeNorm(id)))
+		}
+	}
+	return func(id int) float64 {
+		return h.vectorDistance(q.Embeddings, h.vector(id))
+	}
+}
+
+// nodeDistance returns the distance between two stored elements.
+func (h *HNSW) nodeDistance(a, b int) float64 {
+	if h.halfStored() {
+		return h.halfNodeDistance(a, b)
+	}
+	switch {
+	case h.Metric.isInt():
+		return h.Distance(h.Elements[a], h.Elements[b])
+	case h.Metric.usesNorms():
+		return h.angle(h.cosineWithNorms(h.vector(a), h.vector(b), h.nodeNorm(a), h.nodeNorm(b)))
+	}
+	return h.vectorDistance(h.vector(a), h.vector(b))
+}
+
+// nodeNorm returns the cached norm of a stored element, computing it if the
+// cache has no entry, e.g. while an index is being decoded.
+func (h *HNSW) nodeNorm(id int) float64 {
+	if n, ok := h.norms[id]; ok {
+		return n
+	}
+	return h.norm(h.vector(id))
+}
+
+// RecomputeDistances re-evaluates the stored distance of every connection
+// under the current Metric and restores the heap order of each connection
+// list, e.g. after Metric was changed on a loaded index. The graph itself is
+// kept, so it stays navigable but was shaped for the old metric; rebuild it
+// with ImportVectors(ExportVectors()) for best recall.
+func (h *HNSW) RecomputeDistances() {
+	h.rebuildNorms()
+	for _, layer := range h.Layers {
+		for id, conns := range layer {
+			for i, c := range conns.Candidates {
+				conns.Candidates[i].Distance = h.nodeDistance(id, c.NodeID)
+			}
+			conns.Fix()
+		}
+	}
+}
+
+// rebuildNorms recomputes the norm cache for the current Metric.
+func (h *HNSW) rebuildNorms() {
+	h.norms = make(map[int]float64, len(h.Elements))
+	if h.Metric.usesNorms() {
+		for id := range h.Elements {
+			h.norms[id] = h.norm(h.vector(id))
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// TestKahanSummationOrder builds two elements whose squared distances to
+// the origin are 1+1e-13 and 1+5e-14. The first adds its 1e-13 in 10^5
+// terms of 1e-18, each lost against the leading 1 by a plain sum, which
+// ranks it nearer. Compensated summation keeps them and ranks it farther.
+func TestKahanSummationOrder(t *testing.T) {
+	const dim = 100001
+	a := make([]float64, dim)
+	a[0] = 1
+	for i := 1; i < dim; i++ {
+		a[i] = 1e-9
+	}
+	b := make([]float64, dim)
+	b[0] = math.Sqrt(1 + 5e-14)
+	q := models.Element{Embeddings: make([]float64, dim)}
+	for _, tc := range []struct {
+		opts []Option
+		want []int
+	}{
+		{nil, []int{0, 1}},
+		{[]Option{WithKahanSummation()}, []int{1, 0}},
+	} {
+		h := NewHNSW(10, 4, 1, 0.72, tc.opts...)
+		for id, v := range [][]float64{a, b} {
+			if err := h.Insert(models.Element{ID: id, Embeddings: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := h.KNNSearch(q, 2); !slices.Equal(got, tc.want) {
+			t.Errorf("Kahan %v: KNNSearch = %v, want %v", h.Kahan, got, tc.want)
+		}
+	}
+}
+
+func BenchmarkSquaredL2(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for _, dim := range []int{128, 1024} {
+		x, y := randomVector(r, dim), randomVector(r, dim)
+		for _, kahan := range []bool{false, true} {
+			h := &HNSW{Kahan: kahan}
+			b.Run(fmt.Sprintf("dim=%d/kahan=%v", dim, kahan), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					h.squaredL2(x, y)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkSearchKahan(b *testing.B) {
+	const n, dim = 5000, 128
+	r := rand.New(rand.NewSource(1))
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		vectors[i] = randomVector(r, dim)
+	}
+	queries := make([]models.Element, 100)
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, dim)}
+	}
+	for _, kahan := range []bool{false, true} {
+		opts := []Option{WithSeed(1)}
+		if kahan {
+			opts = append(opts, WithKahanSummation())
+		}
+		h := NewHNSW(100, 16, 6, 0.36, opts...)
+		for i, v := range vectors {
+			h.Insert(models.Element{ID: i, Embeddings: v})
+		}
+		b.Run(fmt.Sprintf("kahan=%v", kahan), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.KNNSearchWithEf(queries[i%len(queries)], 10, 100)
+			}
+		})
+	}
+}
+
+// BenchmarkCosineNorms compares a cosine distance that computes both norms
+// with one reading them from the cache, as node distances do.
+func BenchmarkCosineNorms(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	h := &HNSW{Metric: Cosine}
+	for _, dim := range []int{128, 1024} {
+		x, y := randomVector(r, dim), randomVector(r, dim)
+		nx, ny := norm(x), norm(y)
+		b.Run(fmt.Sprintf("dim=%d/cached=false", dim), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.cosineDistance(x, y)
+			}
+		})
+		b.Run(fmt.Sprintf("dim=%d/cached=true", dim), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.cosineWithNorms(x, y, nx, ny)
+			}
+		})
+	}
+}
+
+func TestAngularDistance(t *testing.T) {
+	h := NewHNSW(10, 4, 1, 0.72, WithMetric(Angular))
+	dist := func(a, b []float64) float64 {
+		return h.Distance(models.Element{Embeddings: a}, models.Element{Embeddings: b})
+	}
+	for _, tc := range []struct {
+		a, b []float64
+		want float64
+	}{
+		{[]float64{1, 0}, []float64{0, 1}, math.Pi / 2},
+		{[]float64{1, 2, 3}, []float64{-2, 1, 0}, math.Pi / 2},
+		{[]float64{3, 4}, []float64{3, 4}, 0},
+		{[]float64{3, 4}, []float64{6, 8}, 0},
+		{[]float64{3, 4}, []float64{-3, -4}, math.Pi},
+	} {
+		if got := dist(tc.a, tc.b); got != tc.want {
+			t.Errorf("Angular(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+	// Rounding leaves the cosine of a random vector with itself just off 1:
+	// below it the angle is tiny, above it the clamp gives 0 rather than NaN.
+	r := rand.New(rand.NewSource(20))
+	for i := 0; i < 1000; i++ {
+		v := randomVector(r, 16)
+		if d := dist(v, v); !(d >= 0 && d < 1e-7) {
+			t.Fatalf("Angular(v, v) = %v for v = %v, want about 0", d, v)
+		}
+		// u is v with its component along w removed, so orthogonal to w.
+		w := randomVector(r, 16)
+		u := slices.Clone(v)
+		k := dot(v, w) / dot(w, w)
+		for j := range u {
+			u[j] -= k * w[j]
+		}
+		if d := dist(u, w); math.Abs(d-math.Pi/2) > 1e-12 {
+			t.Fatalf("Angular(u, w) = %v for orthogonal u and w, want π/2", d)
+		}
+	}
+}
+
+// TestRecomputeDistances checks that recomputing under the same metric
+// changes nothing, and that after a metric change every stored distance is
+// the new one and searches rank by it.
+func TestRecomputeDistances(t *testing.T) {
+	const n, d, K = 500, 8, 10
+	h := buildRandom(t, n, d, 32)
+	r := rand.New(rand.NewSource(33))
+	queries := make([]models.Element, 20)
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, d)}
+	}
+	var before [][]int
+	for _, q := range queries {
+		before = append(before, h.KNNSearchWithEf(q, K, 50))
+	}
+	h.RecomputeDistances()
+	for i, q := range queries {
+		if got := h.KNNSearchWithEf(q, K, 50); !slices.Equal(got, before[i]) {
+			t.Fatalf("same metric: KNNSearchWithEf = %v, want %v", got, before[i])
+		}
+	}
+
+	h.Metric = Cosine
+	h.RecomputeDistances()
+	for lc, layer := range h.Layers {
+		for id, conns := range layer {
+			for _, c := range conns.Candidates {
+				want := h.Distance(models.Element{Embeddings: h.vector(id)}, models.Element{Embeddings: h.vector(c.NodeID)})
+				if math.Abs(c.Distance-want) > 1e-12 {
+					t.Fatalf("layer %d: %d->%d stored at %v, want %v", lc, id, c.NodeID, c.Distance, want)
+				}
+			}
+		}
+	}
+	total := 0.0
+	for _, q := range queries {
+		total += RecallAt(h.KNNSearchWithEf(q, K, 50), h.BruteForceKNN(q, K), K)
+	}
+	if recall := total / float64(len(queries)); recall < 0.9 {
+		t.Errorf("Cosine recall %.3f after RecomputeDistances, want at least 0.9", recall)
+	}
+	checkHeapsPop(t, h, "RecomputeDistances")
+}
@@ -0,0 +1,94 @@
+package hnsw
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// RecallMonitor estimates the live recall of an index from real traffic. A
+// sampled fraction of queries is also answered by BruteForceKNN, and the
+// recall of the graph results against it is averaged over the most recent
+// samples. A falling average points at graph degradation, e.g. after heavy
+// churn, before it shows up elsewhere.
+//
+// A sample costs a full scan of the index, so the sampling rate bounds the
+// overhead: at rate r, the average query pays r brute-force scans. The
+// monitor is safe for concurrent use, but the scans read the index, so with
+// a SafeHNSW call Observe inside View.
+type RecallMonitor struct {
+	h    *HNSW
+	rate float64
+
+	mu      sync.Mutex
+	window  []float64 // Ring of the most recent sample recalls
+	next    int
+	filled  int
+	sum     float64
+	samples int
+}
+
+// NewRecallMonitor creates a monitor for h that samples each query with
+// probability rate and averages over the last window samples.
+func NewRecallMonitor(h *HNSW, rate float64, window int) *RecallMonitor {
+	return &RecallMonitor{
+		h:      h,
+		rate:   rate,
+		window: make([]float64, max(window, 1)),
+	}
+}
+
+// Search runs KNNSearchWithEf and passes the query to Observe.
+func (m *RecallMonitor) Search(q models.Element, K, ef int) []int {
+	res := m.h.KNNSearchWithEf(q, K, ef)
+	m.Observe(q, K, res)
+	return res
+}
+
+// Observe offers a query and the K results the index returned for it. It
+// reports whether the query was sampled.
+func (m *RecallMonitor) Observe(q models.Element, K int, results []int) bool {
+	if K <= 0 || rand.Float64() >= m.rate {
+		return false
+	}
+	truth := m.h.BruteForceKNN(q, K)
+	if len(truth) == 0 {
+		return false
+	}
+	m.record(RecallAt(results, truth, K))
+	return true
+}
+
+// record adds one sample recall to the window.
+func (m *RecallMonitor) record(recall float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.filled == len(m.window) {
+		m.sum -= m.window[m.next]
+	} else {
+		m.filled++
+	}
+	m.window[m.next] = recall
+	m.sum += recall
+	m.next = (m.next + 1) % len(m.window)
+	m.samples++
+}
+
+// Recall returns the average recall over the current window and the number
+// of samples in it. With no samples yet it returns 0, 0.
+func (m *RecallMonitor) Recall() (avg float64, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.filled == 0 {
+		return 0, 0
+	}
+	return m.sum / float64(m.filled), m.filled
+}
+
+// Samples returns the total number of queries sampled so far.
+func (m *RecallMonitor) Samples() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.samples
+}
@@ -0,0 +1,152 @@
+package hnsw
+
+import (
+	"sort"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// Optimize packs the embeddings and layer-0 connection lists of all
+// elements into two contiguous arrays, ordered by a breadth-first traversal
+// of layer 0 from the entry point, so that nodes adjacent in the graph are
+// stored next to each other. Nodes are not relabeled: element IDs are the
+// graph keys and are not changed, and elements are still looked up through
+// the maps. The graph and every search result are unchanged.
+//
+// Elements inserted afterwards keep their own allocations, so Optimize can
+// be re-run after large builds.
+func (h *HNSW) Optimize() {
+	order := h.bfsOrder()
+	if len(order) == 0 {
+		return
+	}
+
+	dims, edges := 0, 0
+	for _, id := range order {
+		dims += len(h.Elements[id].Embeddings)
+		edges += h.Layers[0][id].Len()
+	}
+	vectors := make([]float64, 0, dims)
+	candidates := make([]models.Candidate, 0, edges)
+	for _, id := range order {
+		e := h.Elements[id]
+		start := len(vectors)
+		vectors = append(vectors, e.Embeddings...)
+		// Cap each window at its length so later appends reallocate instead
+		// of overwriting the next node's data.
+		e.Embeddings = vectors[start:len(vectors):len(vectors)]
+		h.Elements[id] = e
+
+		conns := h.Layers[0][id]
+		start = len(candidates)
+		candidates = append(candidates, conns.Candidates...)
+		conns.Candidates = candidates[start:len(candidates):len(candidates)]
+	}
+}
+
+// Shrink reallocates the maps of the index at their current size. Go maps
+// never release the space of deleted keys, so after Compact has removed
+// many nodes the element, level and layer maps still take the memory of
+// their peak size; Shrink copies each into a new map and lets the old one
+// be collected. The contents, and thus the graph and every search result,
+// are unchanged.
+//
+// Shrink needs memory for one copy of the largest map while it runs. Call
+// it after Compact; tombstoned nodes are still part of the graph and are
+// kept.
+func (h *HNSW) Shrink() {
+	h.Elements = shrinkMap(h.Elements)
+	h.Levels = shrinkMap(h.Levels)
+	h.IntVectors = shrinkMap(h.IntVectors)
+	h.HalfVectors = shrinkMap(h.HalfVectors)
+	h.Deleted = shrinkMap(h.Deleted)
+	h.Hidden = shrinkMap(h.Hidden)
+	h.Labels = shrinkMap(h.Labels)
+	h.norms = shrinkMap(h.norms)
+	h.labelIDs = shrinkMap(h.labelIDs)
+	for lc, layer := range h.Layers {
+		h.Layers[lc] = shrinkMap(layer)
+	}
+	h.insertOrder = append([]int(nil), h.insertOrder...)
+}
+
+// shrinkMap returns a copy of m allocated for its current size.
+func shrinkMap[V any](m map[int]V) map[int]V {
+	res := make(map[int]V, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// bfsOrder lists the nodes of layer 0 in breadth-first order from the entry
+// point, followed by any unreachable nodes in ascending ID order.
+func (h *HNSW) bfsOrder() []int {
+	if len(h.Layers) == 0 {
+		return nil
+	}
+	layer := h.Layers[0]
+	order := make([]int, 0, len(layer))
+	visited := make(map[int]bool, len(layer))
+	if _, ok := layer[h.EnterPoint]; ok {
+		visited[h.EnterPoint] = true
+		order = append(order, h.EnterPoint)
+	}
+	for i := 0; i < len(order); i++ {
+		for _, c := range layer[order[i]].Candidates {
+			if !visited[c.NodeID] {
+				visited[c.NodeID] = true
+				order = append(order, c.NodeID)
+			}
+		}
+	}
+	if len(order) < len(layer) {
+		rest := make([]int, 0, len(layer)-len(order))
+		for id := range layer {
+			if !visited[id] {
+				rest = append(rest, id)
+			}
+		}
+		sort.Ints(rest)
+		order = append(order, rest...)
+	}
+	return order
+}
+
+// warmupSink keeps Warmup's reads from being optimized away.
+var warmupSink float64
+
+// Warmup pre-faults memory and warms CPU caches before serving traffic. It
+// reads every vector and connection list once, sequentially, then runs
+// sampleQueries searches using stored elements as queries. The cost is one
+// pass over all vectors and edges, O(N*dim + edges), plus sampleQueries
+// ordinary searches at ef = EfConstruction.
+func (h *HNSW) Warmup(sampleQueries int) {
+	sum := 0.0
+	for _, e := range h.Elements {
+		for _, v := range e.Embeddings {
+			sum += v
+		}
+	}
+	for _, v := range h.HalfVectors {
+		for _, x := range v {
+			sum += float64(x)
+		}
+	}
+	for _, layer := range h.Layers {
+		for _, conns := range layer {
+			for _, c := range conns.Candidates {
+				sum += c.Distance
+			}
+		}
+	}
+	warmupSink = sum
+
+	for id := range h.Elements {
+		if sampleQueries <= 0 {
+			break
+		}
+		h.searchKNN(h.element(id), 1, h.EfConstruction, nil)
+		sampleQueries--
+	}
+}
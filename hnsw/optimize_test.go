@@ -0,0 +1,80 @@
+package hnsw
+
+import (
+	"math/rand"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+func TestOptimizeKeepsResults(t *testing.T) {
+	h := buildRandom(t, 500, 8, 19)
+	r := rand.New(rand.NewSource(20))
+	queries := make([]models.Element, 20)
+	before := make([][]int, len(queries))
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, 8)}
+		before[i] = h.KNNSearchWithEf(queries[i], 10, 40)
+	}
+	h.Optimize()
+	if err := h.HealthCheck(); err != nil {
+		t.Fatalf("after Optimize: %v", err)
+	}
+	for i, q := range queries {
+		if got := h.KNNSearchWithEf(q, 10, 40); !slices.Equal(got, before[i]) {
+			t.Errorf("query %d: %v after Optimize, %v before", i, got, before[i])
+		}
+	}
+	// Later inserts must not overwrite the packed neighbors.
+	if err := h.Insert(models.Element{ID: 500, Embeddings: randomVector(r, 8)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.HealthCheck(); err != nil {
+		t.Fatalf("insert after Optimize: %v", err)
+	}
+}
+
+// heapInUse returns the live heap after a collection.
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// TestShrinkMemory compacts away 90% of an index and checks that Shrink
+// releases most of the map memory the removed elements left behind.
+func TestShrinkMemory(t *testing.T) {
+	n := 20000
+	if testing.Short() {
+		n = 5000
+	}
+	r := rand.New(rand.NewSource(19))
+	h := NewHNSW(8, 4, 3, 0.72, WithSeed(19))
+	for i := 0; i < n; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, 4)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			if err := h.Delete(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	h.Compact()
+	before := heapInUse()
+	h.Shrink()
+	after := heapInUse()
+	t.Logf("live heap %d KB before Shrink, %d KB after", before>>10, after>>10)
+	if after > before/2 {
+		t.Errorf("live heap %d KB after Shrink, want at most half of %d KB", after>>10, before>>10)
+	}
+	if h.Len() != n/10 {
+		t.Errorf("Len = %d after Shrink, want %d", h.Len(), n/10)
+	}
+	runtime.KeepAlive(h)
+}
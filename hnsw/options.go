@@ -0,0 +1,275 @@
+package hnsw
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// Option configures optional behavior of an HNSW index at construction.
+type Option func(*HNSW)
+
+// ProgressFunc receives bulk build progress as done out of total elements.
+type ProgressFunc func(done, total int)
+
+// defaultProgressInterval is the number of inserts between progress reports
+// when WithProgress is given a non-positive interval.
+const defaultProgressInterval = 10000
+
+// WithProgress reports InsertBatch progress to fn every interval inserts and
+// once at the end. fn is called between inserts, without any lock held.
+func WithProgress(fn ProgressFunc, interval int) Option {
+	return func(h *HNSW) {
+		if interval <= 0 {
+			interval = defaultProgressInterval
+		}
+		h.progress = fn
+		h.progressInterval = interval
+	}
+}
+
+// WithSeed makes level generation deterministic. Together with a fixed
+// insertion order, two indexes built with the same seed are identical and
+// serialize to identical bytes.
+func WithSeed(seed int64) Option {
+	return func(h *HNSW) {
+		h.rng = rand.New(rand.NewSource(seed))
+		h.seed = seed
+	}
+}
+
+// WithMetric selects the distance function. The default is L2.
+func WithMetric(m Metric) Option {
+	return func(h *HNSW) {
+		h.Metric = m
+	}
+}
+
+// WithMaxElements bounds the index to n elements not deleted, hidden ones
+// included. When full, Insert first removes the element chosen by policy
+// through Delete; nil selects EvictOldest. Evicted nodes are compacted away
+// in batches of n/10.
+func WithMaxElements(n int, policy EvictionPolicy) Option {
+	return func(h *HNSW) {
+		if policy == nil {
+			policy = EvictOldest
+		}
+		h.maxElements = n
+		h.evict = policy
+	}
+}
+
+// WithExactMatchEpsilon sets the Euclidean distance within which ExactMatch
+// considers two vectors equal.
+func WithExactMatchEpsilon(eps float64) Option {
+	return func(h *HNSW) {
+		h.exactEpsilon = eps
+	}
+}
+
+// defaultAmongThreshold is the largest allowed set KNNSearchAmong scans
+// exhaustively unless WithAmongThreshold says otherwise.
+const defaultAmongThreshold = 2000
+
+// WithAmongThreshold sets the largest allowed set for which KNNSearchAmong
+// and KNNSearchBitset scan the set exhaustively instead of running a
+// filtered graph search. A non-positive n restores the default.
+func WithAmongThreshold(n int) Option {
+	return func(h *HNSW) {
+		h.amongThreshold = n
+	}
+}
+
+// amongLimit returns the WithAmongThreshold size.
+func (h *HNSW) amongLimit() int {
+	if h.amongThreshold <= 0 {
+		return defaultAmongThreshold
+	}
+	return h.amongThreshold
+}
+
+// defaultReachableLimit is the number of nodes ReachableCount visits at
+// most unless WithReachableLimit says otherwise.
+const defaultReachableLimit = 1000000
+
+// WithReachableLimit sets the number of nodes ReachableCount visits before
+// it stops. A non-positive n restores the default of one million.
+func WithReachableLimit(n int) Option {
+	return func(h *HNSW) {
+		h.reachableMax = n
+	}
+}
+
+// reachableLimit returns the WithReachableLimit size.
+func (h *HNSW) reachableLimit() int {
+	if h.reachableMax <= 0 {
+		return defaultReachableLimit
+	}
+	return h.reachableMax
+}
+
+// defaultSortedBelow is the ef below which layer searches keep their
+// results in a sorted slice unless WithSortedResultsBelow says otherwise.
+const defaultSortedBelow = 128
+
+// WithSortedResultsBelow makes layer searches with an ef below n, in
+// searches and inserts alike, keep their best candidates in a slice sorted
+// by insertion instead of a binary heap. For small ef the slice wins: each
+// insertion moves a few contiguous elements, where the heap pays interface
+// conversions on every push and pop. In BenchmarkSearchLayerSorted the
+// slice was faster at ef=10, about even from 64 to 128 and slower from 160
+// on, hence the default of 128. Results are identical either way. A
+// non-positive n restores the default; n = 1 always uses the heap.
+func WithSortedResultsBelow(n int) Option {
+	return func(h *HNSW) {
+		h.sortedBelow = n
+	}
+}
+
+// sortedResultsLimit returns the WithSortedResultsBelow ef.
+func (h *HNSW) sortedResultsLimit() int {
+	if h.sortedBelow <= 0 {
+		return defaultSortedBelow
+	}
+	return h.sortedBelow
+}
+
+// WithProjection passes every inserted vector through fn before it is
+// stored and fixes the index dimension to dim. Insert rejects vectors that
+// do not have dim values after projection. Use PadOrTruncate for plain
+// padding or truncation, and Project to map queries the same way.
+func WithProjection(fn ProjectFunc, dim int) Option {
+	return func(h *HNSW) {
+		h.project = fn
+		h.Dim = dim
+	}
+}
+
+// WithPruningAlpha relaxes neighbor selection as in DiskANN: a candidate is
+// pruned only if alpha times its distance to an already selected neighbor
+// is below its distance to the new node. alpha = 1 is the standard HNSW
+// heuristic. Values above 1 prune less, favoring long-range edges. Because
+// inserts fill the remaining slots with pruned candidates anyway, the effect
+// here is on which neighbors are kept, not how many. BenchmarkPruningAlpha
+// found no gain on uniform random data, so measure recall before changing
+// it. It applies to L2, Cosine and Angular; with InnerProduct, distances
+// can be negative and alpha should stay 1.
+func WithPruningAlpha(alpha float64) Option {
+	return func(h *HNSW) {
+		h.pruneAlpha = alpha
+	}
+}
+
+// WithSymmetricRepair makes Compact finish with RepairSymmetry, so the
+// graph left after removing deleted nodes has two-way connections.
+func WithSymmetricRepair() Option {
+	return func(h *HNSW) {
+		h.symmetricRepair = true
+	}
+}
+
+// WithRefineDistance sets a second distance function for the steps that
+// decide graph quality and result order: neighbor selection during inserts
+// and repairs, and the final ranking of the ef candidates of a search.
+// Graph traversal keeps using Metric. This is the refine step of quantized
+// ANN: use it when Metric is cheap but approximate, e.g. over compressed
+// vectors, and fn is exact but costlier. Searches that supply their own
+// query distance, such as KNNSearchInt and KNNSearchMasked, are not
+// re-ranked. Connection lists still store Metric distances.
+func WithRefineDistance(fn DistanceFunc) Option {
+	return func(h *HNSW) {
+		h.refine = fn
+	}
+}
+
+// WithBuildThenPrune makes BuildBulk keep every reverse connection while
+// it links nodes, letting connection lists grow past 2*M, and trim each
+// list once at the end with the neighbor selection heuristic. Nodes linked
+// late then compete on equal terms with early ones instead of being
+// dropped by greedy eviction as they arrive. Insert is unaffected.
+func WithBuildThenPrune() Option {
+	return func(h *HNSW) {
+		h.deferPruning = true
+	}
+}
+
+// WithVectorStore makes the index read vectors from vs instead of keeping
+// them in Elements; see VectorStore. It takes precedence over WithFloat16.
+func WithVectorStore(vs VectorStore) Option {
+	return func(h *HNSW) {
+		h.vectorStore = vs
+	}
+}
+
+// WithFloat16 stores vectors in half precision, in HalfVectors, cutting
+// vector memory to a quarter of float64 at a small loss of accuracy: a half
+// keeps about 3 significant decimal digits, and magnitudes above 65504
+// overflow. Queries are not converted. Integer metrics are unaffected.
+func WithFloat16() Option {
+	return func(h *HNSW) {
+		h.Float16 = true
+	}
+}
+
+// WithConnectionPolicy sets how a full connection list chooses which
+// connection to drop when an insert links one more node to it; see
+// ConnectionPolicy. The default, KeepClosest, drops the farthest one.
+func WithConnectionPolicy(p ConnectionPolicy) Option {
+	return func(h *HNSW) {
+		h.connPolicy = p
+	}
+}
+
+// WithImprovementDelta makes a full connection list replace its farthest
+// connection only with a node closer than it by more than delta, in units
+// of the index metric, so that near-ties do not swap connections back and
+// forth during builds. The default, 0, replaces on any improvement.
+// Connection policies set by WithConnectionPolicy ignore delta.
+func WithImprovementDelta(delta float64) Option {
+	return func(h *HNSW) {
+		h.improvementDelta = delta
+	}
+}
+
+// WithBatchDistance makes layer searches compute the distances to the
+// neighbors of each expanded node with one call to fn, e.g. to offload
+// them to an accelerator; see BatchDistanceFunc. Results are unchanged as
+// long as fn agrees with ScalarBatchDistance. Searches with their own
+// distance, such as masked and integer searches, do not use it.
+func WithBatchDistance(fn BatchDistanceFunc) Option {
+	return func(h *HNSW) {
+		h.batchDist = fn
+	}
+}
+
+// WithKahanSummation accumulates the sums inside float distances with
+// compensated (Kahan) summation, keeping the rounding error of a distance
+// independent of the dimension. With plain float64 sums the error grows
+// with the number of terms, so that at 10^5 dimensions neighbors whose
+// squared distances differ in the 14th significant digit can swap places.
+// BenchmarkSquaredL2 measured the kernel about 3x slower and
+// BenchmarkSearchKahan searches about 1.5x slower at 128 dimensions.
+// Integer metrics and WithFloat16 vectors are unaffected.
+func WithKahanSummation() Option {
+	return func(h *HNSW) {
+		h.Kahan = true
+	}
+}
+
+// WithExpectedElements presizes the element maps for n elements and the
+// layer slice for the expected number of layers, ln(n)*mL + 1 capped at
+// MaxLayers + 1. Inserts behave the same; only the initial capacities change.
+func WithExpectedElements(n int) Option {
+	return func(h *HNSW) {
+		if n <= 0 {
+			return
+		}
+		layers := min(int(math.Log(float64(n))*h.NormalizationML)+1, h.MaxLayers+1)
+		h.Layers = make([]map[int]*hnswheap.CandidateHeap, 0, max(layers, 1))
+		h.Elements = make(map[int]models.Element, n)
+		h.Levels = make(map[int]int, n)
+		h.norms = make(map[int]float64, n)
+	}
+}
@@ -0,0 +1,44 @@
+package hnsw
+
+import "math"
+
+// Preset constructors. Each fixes the dimension of the index to dim and
+// picks M and efConstruction for a common trade-off, with the level
+// normalization mL = 1/ln(M) recommended by the HNSW paper and room for 16
+// layers. Options are applied as with NewHNSW.
+//
+//	preset         M   efConstruction
+//	NewFastBuild   8   64
+//	NewDefault     16  200
+//	NewHighRecall  32  400
+
+// presetMaxLayers is the layer limit of the presets; with mL = 1/ln(M) it is
+// only reached by indexes far larger than memory allows.
+const presetMaxLayers = 16
+
+// NewDefault creates an index with parameters suited to general use.
+func NewDefault(dim int, opts ...Option) *HNSW {
+	return newPreset(dim, 16, 200, opts)
+}
+
+// NewHighRecall creates an index that trades build time and memory for
+// recall, with twice the connections and candidate list of NewDefault.
+func NewHighRecall(dim int, opts ...Option) *HNSW {
+	return newPreset(dim, 32, 400, opts)
+}
+
+// NewFastBuild creates an index that builds quickly and stays small, at the
+// cost of recall. Searches may need a larger ef to compensate.
+func NewFastBuild(dim int, opts ...Option) *HNSW {
+	return newPreset(dim, 8, 64, opts)
+}
+
+// newPreset creates an index with the given M and efConstruction. A
+// dimension fixed by WithProjection takes precedence over dim.
+func newPreset(dim, M, efConstruction int, opts []Option) *HNSW {
+	h := NewHNSW(efConstruction, M, presetMaxLayers, 1/math.Log(float64(M)), opts...)
+	if h.Dim == 0 {
+		h.Dim = dim
+	}
+	return h
+}
@@ -0,0 +1,58 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// ErrDimensionMismatch is returned when a vector does not have the index
+// dimension.
+var ErrDimensionMismatch = errors.New("hnsw: dimension mismatch")
+
+// ErrEmptyVector is returned when an inserted element has no embeddings.
+var ErrEmptyVector = errors.New("hnsw: empty vector")
+
+// ProjectFunc maps an input vector to the vector stored in the index, e.g.
+// to bring embeddings of different models to a common dimension.
+type ProjectFunc func([]float64) []float64
+
+// PadOrTruncate returns a ProjectFunc that cuts vectors longer than dim and
+// pads shorter ones with zeros. The result never aliases the input.
+func PadOrTruncate(dim int) ProjectFunc {
+	return func(v []float64) []float64 {
+		res := make([]float64, dim)
+		copy(res, v)
+		return res
+	}
+}
+
+// Project applies the projection configured with WithProjection to vec, or
+// returns vec unchanged if there is none. Queries built from the same model
+// outputs as the inserted vectors should be projected the same way.
+func (h *HNSW) Project(vec []float64) []float64 {
+	if h.project == nil {
+		return vec
+	}
+	return h.project(vec)
+}
+
+// prepare projects q for storage and checks it against the index dimension,
+// which is fixed by WithProjection or else by the first inserted element.
+// Empty vectors are rejected before projection: an element without a
+// vector would lie at distance 0 from everything under L2, and the first
+// one would even fix the dimension at 0.
+func (h *HNSW) prepare(q models.Element) (models.Element, error) {
+	if h.Metric.isInt() {
+		return q, fmt.Errorf("hnsw: index uses integer metric %q, insert with InsertInt", h.Metric)
+	}
+	if len(q.Embeddings) == 0 {
+		return q, fmt.Errorf("element %d: %w", q.ID, ErrEmptyVector)
+	}
+	q.Embeddings = h.Project(q.Embeddings)
+	if h.Dim > 0 && len(q.Embeddings) != h.Dim {
+		return q, fmt.Errorf("element %d: dimension %d, index has %d: %w", q.ID, len(q.Embeddings), h.Dim, ErrDimensionMismatch)
+	}
+	return q, nil
+}
@@ -0,0 +1,206 @@
+package hnsw
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// Background rebuilds. A graph that has seen many deletes, updates or
+// parameter changes routes worse than one built from scratch over the same
+// elements. BackgroundRebuild builds that fresh graph while the index keeps
+// serving, in three steps:
+//
+//  1. Under the shared lock, copy the live and hidden elements.
+//  2. Without any lock, insert the copies into a new, private graph with
+//     the current parameters.
+//  3. Bring the new graph up to date with the elements as they are now:
+//     list the changes under the shared lock and apply them without it,
+//     then list and apply the changes made in the meantime under the
+//     exclusive lock and swap the new graph in before releasing it.
+//
+// Consistency: until the swap, searches and mutations use the old graph as
+// usual and see every change immediately. Step 3 replays the net effect of
+// the changes made meanwhile, whichever method made them: elements that
+// were inserted, or re-inserted with another vector, are inserted into the
+// new graph, and elements that were removed are removed from it. Deletes
+// stay tombstones in the new graph, except those already deleted when the
+// rebuild started, which the rebuild drops as Compact would. The swap is a
+// single exclusive critical section, so a search runs entirely on the old
+// graph or entirely on the new one, and no change is lost or applied twice.
+//
+// Only the graph is replaced: the layers, the levels and the entry point.
+// Elements, vectors, labels and all settings of the index are kept, so
+// settings changed during the rebuild stay in effect, but the new graph is
+// built with the construction parameters in effect at step 1.
+//
+// Costs: the copy of step 1 and the new graph hold a second set of vectors
+// and connections until the swap. Under the exclusive lock, step 3
+// compares the vector of every element, about one pass over the vectors,
+// and applies the few changes made since the previous pass; inserts and
+// searches wait meanwhile.
+
+// BackgroundRebuild rebuilds the graph in a new goroutine as described
+// above and returns a channel that receives nil once the new graph is in
+// use, or the error that stopped the rebuild, in which case the old graph
+// stays in use. Only one rebuild runs at a time; the channel of a call made
+// while one is running receives an error at once. Integer metrics are not
+// supported.
+func (s *SafeHNSW) BackgroundRebuild() <-chan error {
+	done := make(chan error, 1)
+	if !s.rebuilding.CompareAndSwap(false, true) {
+		done <- errors.New("hnsw: rebuild already running")
+		return done
+	}
+	s.mu.RLock()
+	if s.h.Metric.isInt() {
+		s.mu.RUnlock()
+		s.rebuilding.Store(false)
+		done <- errors.New("hnsw: rebuild does not support integer metrics")
+		return done
+	}
+	fresh := s.h.emptyClone()
+	elems := s.h.snapshotGraphElements()
+	s.mu.RUnlock()
+	go func() {
+		defer s.rebuilding.Store(false)
+		done <- s.rebuild(fresh, elems)
+	}()
+	return done
+}
+
+// rebuild runs steps 2 and 3 of BackgroundRebuild.
+func (s *SafeHNSW) rebuild(fresh *HNSW, elems []models.Element) error {
+	for _, e := range elems {
+		if err := fresh.Insert(e); err != nil {
+			return err
+		}
+	}
+	// Apply the bulk of the changes made meanwhile outside the lock, so the
+	// exclusive section only has to apply those made during this pass.
+	s.mu.RLock()
+	d := s.h.rebuildDelta(fresh)
+	s.mu.RUnlock()
+	if err := fresh.applyDelta(d); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := fresh.applyDelta(s.h.rebuildDelta(fresh)); err != nil {
+		return err
+	}
+	s.h.adoptGraph(fresh)
+	return nil
+}
+
+// emptyClone returns an empty index with the configuration of h that shares
+// no storage with it. Vectors inserted into it are stored as given: they
+// come from h and are projected already. It never evicts, since h does.
+func (h *HNSW) emptyClone() *HNSW {
+	c := *h
+	c.Layers = []map[int]*hnswheap.CandidateHeap{}
+	c.EnterPoint = -1
+	c.Elements = make(map[int]models.Element, len(h.Elements))
+	c.Levels = make(map[int]int, len(h.Levels))
+	c.IntVectors = make(map[int][]int32)
+	c.HalfVectors = make(map[int][]uint16)
+	c.Deleted = make(map[int]bool)
+	c.Hidden = make(map[int]bool)
+	c.Labels = make(map[int]int)
+	c.Metadata = nil
+	c.norms = make(map[int]float64)
+	c.labelIDs = make(map[int]int)
+	c.nextID = 0
+	c.freeIDs = nil
+	c.insertOrder = nil
+	c.progress = nil
+	c.project = nil
+	c.maxElements = 0
+	c.evict = nil
+	if h.rng != nil {
+		c.rng = rand.New(rand.NewSource(h.seed))
+	}
+	return &c
+}
+
+// snapshotGraphElements returns copies of the elements that have a place in
+// the graph, deleted ones excepted, in ascending ID order.
+func (h *HNSW) snapshotGraphElements() []models.Element {
+	res := make([]models.Element, 0, len(h.Elements)-len(h.Deleted))
+	for _, id := range sortedKeys(h.Elements) {
+		if !h.Deleted[id] {
+			res = append(res, h.elementCopy(id))
+		}
+	}
+	return res
+}
+
+// elementCopy is element with a vector the caller may keep after the lock
+// is released.
+func (h *HNSW) elementCopy(id int) models.Element {
+	e := h.element(id)
+	if h.vectorStore == nil && !h.Float16 {
+		e.Embeddings = append([]float64(nil), e.Embeddings...)
+	}
+	return e
+}
+
+// rebuildDelta lists the changes that bring the graph of fresh up to date
+// with the elements of h.
+type rebuildDelta struct {
+	gone  map[int]bool     // Nodes of elements no longer in h
+	stale []models.Element // Elements missing from fresh or with another vector there
+}
+
+// rebuildDelta compares fresh with h. Elements deleted in h since the
+// snapshot stay in fresh, to be tombstones after adoptGraph, but are
+// compared too, since they may have been replaced before the delete.
+func (h *HNSW) rebuildDelta(fresh *HNSW) rebuildDelta {
+	d := rebuildDelta{gone: map[int]bool{}}
+	for _, id := range sortedKeys(fresh.Elements) {
+		if _, ok := h.Elements[id]; !ok {
+			d.gone[id] = true
+		}
+	}
+	for _, id := range sortedKeys(h.Elements) {
+		_, ok := fresh.Elements[id]
+		if h.Deleted[id] && !ok || ok && slices.Equal(h.vector(id), fresh.vector(id)) {
+			continue
+		}
+		d.stale = append(d.stale, h.elementCopy(id))
+	}
+	return d
+}
+
+// applyDelta applies d to h, the new graph of a rebuild.
+func (h *HNSW) applyDelta(d rebuildDelta) error {
+	if len(d.gone) > 0 {
+		h.unlinkNodes(d.gone)
+	}
+	for _, e := range d.stale {
+		// Inserting over a tombstone purges the old node first.
+		h.Delete(e.ID)
+		if err := h.Insert(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adoptGraph replaces the graph of h with that of fresh, brought up to date
+// by applyDelta. Deleted elements of h without a node in the new graph are
+// dropped.
+func (h *HNSW) adoptGraph(fresh *HNSW) {
+	for id := range h.Deleted {
+		if _, ok := fresh.Levels[id]; !ok {
+			h.dropElement(id)
+		}
+	}
+	h.Layers = fresh.Layers
+	h.Levels = fresh.Levels
+	h.EnterPoint = fresh.EnterPoint
+	h.repairEnterPoint()
+}
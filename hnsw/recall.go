@@ -0,0 +1,99 @@
+package hnsw
+
+import "github.com/lblclass/hnswgo/models"
+
+// BruteForceKNN returns the exact K nearest neighbors of q by scanning every
+// searchable element. It is meant for producing ground truth, not for serving.
+func (h *HNSW) BruteForceKNN(q models.Element, K int) []int {
+	ids := make([]int, 0, len(h.Elements))
+	for id := range h.Elements {
+		ids = append(ids, id)
+	}
+	return candidateIDs(h.exactKNN(q, K, ids))
+}
+
+// exactKNN returns the K live elements among ids nearest to q, sorted by
+// ascending distance with ties broken by ID. Missing, deleted, hidden and
+// repeated IDs are ignored.
+func (h *HNSW) exactKNN(q models.Element, K int, ids []int) []models.Candidate {
+	dist := h.queryDistance(q)
+	seen := make(map[int]bool, len(ids))
+	candidates := make([]models.Candidate, 0, len(ids))
+	for _, id := range ids {
+		if !h.searchable(id) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		candidates = append(candidates, models.Candidate{NodeID: id, Distance: dist(id)})
+	}
+	sortCandidates(candidates)
+	return candidates[:min(max(K, 0), len(candidates))]
+}
+
+// EvaluateRecall runs every query with the given ef and returns the mean
+// RecallAt K against groundTruth, where groundTruth[i] holds the exact
+// neighbors of queries[i] (e.g. from BruteForceKNN).
+func (h *HNSW) EvaluateRecall(queries []models.Element, groundTruth [][]int, K, ef int) float64 {
+	if len(queries) == 0 || K <= 0 {
+		return 0
+	}
+	total := 0.0
+	for i, q := range queries {
+		total += RecallAt(h.KNNSearchWithEf(q, K, ef), groundTruth[i], K)
+	}
+	return total / float64(len(queries))
+}
+
+// RecallAt returns the recall@K of one result: the fraction of the first K
+// IDs of exact, the true neighbors in order, found among the first K IDs
+// of approx. When exact holds fewer than K IDs, e.g. because the index has
+// fewer elements, the fraction is of those it holds, so a complete result
+// scores 1. An empty exact also scores 1, and a non-positive K scores 0.
+// Repeated IDs in approx count once. EvaluateRecall and RecallMonitor use
+// this definition.
+func RecallAt(approx, exact []int, K int) float64 {
+	if K <= 0 {
+		return 0
+	}
+	exact = exact[:min(K, len(exact))]
+	if len(exact) == 0 {
+		return 1
+	}
+	truth := make(map[int]bool, len(exact))
+	for _, id := range exact {
+		truth[id] = true
+	}
+	hits := 0
+	for _, id := range approx[:min(K, len(approx))] {
+		if truth[id] {
+			hits++
+			delete(truth, id)
+		}
+	}
+	return float64(hits) / float64(len(exact))
+}
+
+// AutoTuneEf returns the smallest search ef whose recall@K on the validation
+// queries reaches targetRecall. The upper bound is found by doubling ef and
+// then narrowed by binary search; if the target is never reached the largest
+// useful ef (the number of stored elements) is returned.
+func (h *HNSW) AutoTuneEf(queries []models.Element, groundTruth [][]int, K int, targetRecall float64) int {
+	lo, hi := K, max(K, 1)
+	maxEf := max(len(h.Elements), K)
+	for h.EvaluateRecall(queries, groundTruth, K, hi) < targetRecall {
+		if hi >= maxEf {
+			return maxEf
+		}
+		lo = hi + 1
+		hi = min(hi*2, maxEf)
+	}
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if h.EvaluateRecall(queries, groundTruth, K, mid) >= targetRecall {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return hi
+}
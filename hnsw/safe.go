@@ -0,0 +1,231 @@
+package hnsw
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// SafeHNSW wraps an HNSW index for concurrent use: mutations take an
+// exclusive lock and searches a shared one, so searches run in parallel
+// with each other but not with inserts.
+//
+// Each insert holds the lock from start to end, including the first insert
+// into an empty index, which creates the layers and the entry point. When
+// goroutines race to insert into an empty index, exactly one bootstraps it
+// and the others wait and then link into the graph it started; searches in
+// the meantime see either the empty index or the bootstrapped one.
+//
+// Use HNSW directly when a single goroutine builds or queries the index;
+// it carries no locking overhead. Use SafeHNSW when goroutines insert and
+// search concurrently. Operations without a wrapper method are available
+// through View and Update.
+type SafeHNSW struct {
+	mu sync.RWMutex
+	h  *HNSW
+
+	maintMu sync.Mutex   // Guards maint
+	maint   *maintenance // Running maintenance loop, nil when stopped
+
+	rebuilding atomic.Bool // A BackgroundRebuild is running
+}
+
+// NewSafeHNSW wraps h. h must not be used directly afterwards.
+func NewSafeHNSW(h *HNSW) *SafeHNSW {
+	return &SafeHNSW{h: h}
+}
+
+// View runs fn with the index under a shared lock. fn must not modify it.
+func (s *SafeHNSW) View(fn func(h *HNSW)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.h)
+}
+
+// Update runs fn with the index under an exclusive lock.
+func (s *SafeHNSW) Update(fn func(h *HNSW)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.h)
+}
+
+// Insert adds a new element into the index.
+func (s *SafeHNSW) Insert(q models.Element) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Insert(q)
+}
+
+// InsertUnique inserts q unless an element within epsilon exists.
+func (s *SafeHNSW) InsertUnique(q models.Element, epsilon float64) (bool, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.InsertUnique(q, epsilon)
+}
+
+// InsertBatch inserts elems in order, taking the lock per element so that
+// searches can interleave. Progress is reported without the lock held. It
+// stops at the first element Insert rejects.
+func (s *SafeHNSW) InsertBatch(elems []models.Element) error {
+	for i, e := range elems {
+		if err := s.Insert(e); err != nil {
+			return err
+		}
+		s.h.reportProgress(i+1, len(elems))
+	}
+	return nil
+}
+
+// Delete marks element id as deleted.
+func (s *SafeHNSW) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Delete(id)
+}
+
+// SoftDelete hides element id from searches.
+func (s *SafeHNSW) SoftDelete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.SoftDelete(id)
+}
+
+// Undelete makes a hidden element searchable again.
+func (s *SafeHNSW) Undelete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Undelete(id)
+}
+
+// Neighbors returns a copy of the connections of node id at a layer.
+func (s *SafeHNSW) Neighbors(id, layer int) []models.Candidate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.Neighbors(id, layer)
+}
+
+// ElementsAtLayer returns the IDs of the nodes present at a layer.
+func (s *SafeHNSW) ElementsAtLayer(layer int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.ElementsAtLayer(layer)
+}
+
+// Neighborhood returns the nodes within hops connections of id at a layer.
+func (s *SafeHNSW) Neighborhood(id, hops, layer int) map[int]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.Neighborhood(id, hops, layer)
+}
+
+// SetNeighbors replaces the connections of a node at one layer.
+func (s *SafeHNSW) SetNeighbors(id, layer int, neighbors []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.SetNeighbors(id, layer, neighbors)
+}
+
+// SetDefaultEf sets the search ef of KNNSearch.
+func (s *SafeHNSW) SetDefaultEf(ef int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.h.SetDefaultEf(ef)
+}
+
+// Compact removes deleted nodes from the graph.
+func (s *SafeHNSW) Compact() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Compact()
+}
+
+// Shrink reallocates the maps of the index at their current size.
+func (s *SafeHNSW) Shrink() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.h.Shrink()
+}
+
+// Reset empties the index, keeping its configuration.
+func (s *SafeHNSW) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.h.Reset()
+}
+
+// Get returns the stored element with the given ID.
+func (s *SafeHNSW) Get(id int) (models.Element, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.Get(id)
+}
+
+// Len returns the number of live elements.
+func (s *SafeHNSW) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.Len()
+}
+
+// KNNSearch finds the K approximate nearest neighbors of q.
+func (s *SafeHNSW) KNNSearch(q models.Element, K int) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.KNNSearch(q, K)
+}
+
+// KNNSearchWithEf finds the K approximate nearest neighbors of q with an
+// explicit search ef.
+func (s *SafeHNSW) KNNSearchWithEf(q models.Element, K, ef int) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.KNNSearchWithEf(q, K, ef)
+}
+
+// KNNSearchMaxDist finds the K approximate nearest neighbors of q within
+// maxDist.
+func (s *SafeHNSW) KNNSearchMaxDist(q models.Element, K, ef int, maxDist float64) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.KNNSearchMaxDist(q, K, ef, maxDist)
+}
+
+// KNNSearchTimeRange finds the K approximate nearest neighbors of q created
+// within [after, before].
+func (s *SafeHNSW) KNNSearchTimeRange(q models.Element, K, ef int, after, before time.Time) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.KNNSearchTimeRange(q, K, ef, after, before)
+}
+
+// ReachableCount counts the searchable elements reachable from the entry
+// point at layer 0.
+func (s *SafeHNSW) ReachableCount() (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.ReachableCount()
+}
+
+// Stats returns a summary of the index.
+func (s *SafeHNSW) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.Stats()
+}
+
+// Save writes the index to w in the compact gob format.
+func (s *SafeHNSW) Save(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.Save(w)
+}
+
+// SaveIndex writes the index to path in the index file format.
+func (s *SafeHNSW) SaveIndex(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.h.SaveIndex(path)
+}
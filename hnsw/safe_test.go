@@ -0,0 +1,48 @@
+package hnsw
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// TestSafeHNSWConcurrentBootstrap starts many goroutines inserting into and
+// searching an empty SafeHNSW at once, so that several reach the first
+// insert together. Run it with -race.
+func TestSafeHNSWConcurrentBootstrap(t *testing.T) {
+	const goroutines, perGoroutine = 16, 3
+	indexes := 200
+	if testing.Short() {
+		indexes = 20
+	}
+	for n := 0; n < indexes; n++ {
+		h := NewHNSW(20, 4, 3, 0.72, WithSeed(int64(n)))
+		s := NewSafeHNSW(h)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				r := rand.New(rand.NewSource(int64(n*goroutines + g)))
+				<-start
+				for i := 0; i < perGoroutine; i++ {
+					if err := s.Insert(models.Element{ID: g*perGoroutine + i, Embeddings: randomVector(r, 4)}); err != nil {
+						t.Errorf("Insert: %v", err)
+					}
+					s.KNNSearch(models.Element{Embeddings: randomVector(r, 4)}, 3)
+				}
+			}(g)
+		}
+		close(start)
+		wg.Wait()
+		if got := s.Len(); got != goroutines*perGoroutine {
+			t.Fatalf("index %d: Len = %d, want %d", n, got, goroutines*perGoroutine)
+		}
+		if err := h.HealthCheck(); err != nil {
+			t.Fatalf("index %d: %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// KNNSearchByID finds the K approximate nearest neighbors of the stored
+// element id, excluding id itself from the results.
+func (h *HNSW) KNNSearchByID(id int, K, ef int) ([]int, error) {
+	return h.KNNSearchByIDExclude(id, K, ef, true)
+}
+
+// KNNSearchByIDExclude finds the K approximate nearest neighbors of the
+// stored element id. With excludeSelf, id is dropped from the results and
+// K+1 neighbors are fetched to compensate, so K other elements are returned
+// whenever the index holds that many.
+func (h *HNSW) KNNSearchByIDExclude(id int, K, ef int, excludeSelf bool) ([]int, error) {
+	q, ok := h.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("element %d: %w", id, ErrNotFound)
+	}
+	if !excludeSelf {
+		return h.KNNSearchWithEf(q, K, ef), nil
+	}
+	res := make([]int, 0, K)
+	for _, n := range h.KNNSearchWithEf(q, K+1, max(ef, K+1)) {
+		if n != id && len(res) < K {
+			res = append(res, n)
+		}
+	}
+	return res, nil
+}
+
+// KNNSearchElements returns the K approximate nearest elements of q, with
+// their Msg and other fields, sorted by ascending distance.
+func (h *HNSW) KNNSearchElements(q models.Element, K, ef int) []models.Element {
+	candidates := h.searchKNN(q, K, ef, nil)
+	res := make([]models.Element, len(candidates))
+	for i, c := range candidates {
+		res[i] = h.element(c.NodeID)
+	}
+	return res
+}
+
+// KNNSearchAmong returns the K nearest neighbors of q among the allowed IDs
+// only. Small allowed sets, up to the WithAmongThreshold size, are scanned
+// exhaustively, which is exact and cheaper than a graph walk that must step
+// over the excluded nodes. Larger sets use a graph search that traverses
+// every node but only returns allowed ones, with ef = max(K, EfConstruction).
+func (h *HNSW) KNNSearchAmong(q models.Element, K int, allowed []int) []int {
+	if len(allowed) <= h.amongLimit() {
+		return candidateIDs(h.exactKNN(q, K, allowed))
+	}
+	set := make(map[int]bool, len(allowed))
+	for _, id := range allowed {
+		set[id] = true
+	}
+	opts := &layerSearch{filter: func(id int) bool { return set[id] }}
+	return candidateIDs(h.searchKNN(q, K, max(K, h.EfConstruction), opts))
+}
+
+// KNNSearchTimeRange is KNNSearchWithEf restricted to elements whose
+// CreatedAt lies in [after, before], both bounds inclusive; a zero bound
+// leaves that side open. Elements without a CreatedAt have the zero time,
+// so they only match when after is zero. The search traverses elements
+// outside the range like deleted ones, so ef counts matching elements, but
+// a narrow range still costs more hops than an unfiltered search and may
+// return fewer than K results when few matches are reachable.
+func (h *HNSW) KNNSearchTimeRange(q models.Element, K, ef int, after, before time.Time) []int {
+	opts := &layerSearch{filter: func(id int) bool {
+		t := h.Elements[id].CreatedAt
+		return !t.Before(after) && (before.IsZero() || !t.After(before))
+	}}
+	return candidateIDs(h.searchKNN(q, K, ef, opts))
+}
+
+// TracedCandidate is a search result tagged with the highest layer at which
+// the search visited it.
+type TracedCandidate struct {
+	NodeID   int
+	Distance float64
+	Layer    int
+}
+
+// KNNSearchTraced is KNNSearchWithEf for diagnosing navigability: each
+// result carries the layer at which the descent first reached it, so
+// results found in upper layers show how well the hierarchy routes toward
+// q. Tracing records every visited node, so it is slower than a plain
+// search; the untraced path only pays a nil check.
+func (h *HNSW) KNNSearchTraced(q models.Element, K, ef int) []TracedCandidate {
+	opts := &layerSearch{trace: make(map[int]int)}
+	candidates := h.searchKNN(q, K, ef, opts)
+	res := make([]TracedCandidate, len(candidates))
+	for i, c := range candidates {
+		res[i] = TracedCandidate{NodeID: c.NodeID, Distance: c.Distance, Layer: opts.trace[c.NodeID]}
+	}
+	return res
+}
+
+// KNNSearchVerbose is KNNSearchWithEf for debugging unexpected results: it
+// also returns every node the search visited, at any layer, with its
+// distance to q, nearest first. Visited nodes include the results, and
+// deleted and hidden nodes the search stepped through, so near-misses
+// show up just after the K results. Their distances are in the index
+// metric, even where WithRefineDistance re-ranks the results. Like
+// KNNSearchTraced, it records every visited node; the plain search paths
+// do not.
+func (h *HNSW) KNNSearchVerbose(q models.Element, K, ef int) (results, visited []models.Candidate) {
+	opts := &layerSearch{trace: make(map[int]int)}
+	results = h.searchKNN(q, K, ef, opts)
+	visited = make([]models.Candidate, 0, len(opts.trace))
+	for id := range opts.trace {
+		visited = append(visited, models.Candidate{NodeID: id, Distance: opts.dist(id)})
+	}
+	sortCandidates(visited)
+	return results, visited
+}
+
+// KNNSearchLayerEf is KNNSearchWithEf with an ef per layer: layerEf[l] is
+// the ef at layer l, with layer 0 the base layer. Missing and non-positive
+// entries default to 1 for upper layers and K for layer 0, the values of
+// KNNSearch, and the layer 0 ef is raised to K if smaller. A wider search
+// at an upper layer hands the closest node it finds to the layer below,
+// which can improve the entry point on graphs whose upper layers route
+// poorly. layerEf may not be longer than the number of layers.
+func (h *HNSW) KNNSearchLayerEf(q models.Element, K int, layerEf []int) ([]int, error) {
+	if len(layerEf) > len(h.Layers) {
+		return nil, fmt.Errorf("hnsw: %d layer efs for %d layers", len(layerEf), len(h.Layers))
+	}
+	ef := K
+	if len(layerEf) > 0 && layerEf[0] > 0 {
+		ef = layerEf[0]
+	}
+	return candidateIDs(h.searchKNN(q, K, ef, &layerSearch{upperEf: layerEf})), nil
+}
+
+// ScoredResult is a search result with a similarity score in [0, 1], higher
+// meaning more similar; see Metric.Score.
+type ScoredResult struct {
+	NodeID   int
+	Distance float64
+	Score    float64
+}
+
+// KNNSearchScored is KNNSearchWithEf with each result scored by
+// Metric.Score, a relevance measure that reads the same across metrics.
+func (h *HNSW) KNNSearchScored(q models.Element, K, ef int) []ScoredResult {
+	candidates := h.searchKNN(q, K, ef, nil)
+	res := make([]ScoredResult, len(candidates))
+	for i, c := range candidates {
+		res[i] = ScoredResult{NodeID: c.NodeID, Distance: c.Distance, Score: h.Metric.Score(c.Distance)}
+	}
+	return res
+}
+
+// KNNSearchGrouped returns the GroupIDs of the K groups whose best-matching
+// member is closest to q (max-sim aggregation), nearest group first.
+func (h *HNSW) KNNSearchGrouped(q models.Element, K int) []int {
+	best := searchDistinct(h, q, K, max(h.EfConstruction, K), func(e models.Element) int {
+		return e.GroupID
+	})
+	res := make([]int, len(best))
+	for i, c := range best {
+		res[i] = h.Elements[c.NodeID].GroupID
+	}
+	return res
+}
+
+// KNNSearchDedup returns the IDs of the K nearest elements with distinct
+// key(element), keeping the closest representative of each key.
+func (h *HNSW) KNNSearchDedup(q models.Element, K, ef int, key func(models.Element) string) []int {
+	return candidateIDs(searchDistinct(h, q, K, max(ef, K), key))
+}
+
+// KNNSearchDesc returns the result of KNNSearchWithEf(q, K, ef) in reverse:
+// the farthest of the K neighbors first and the nearest last, with ties in
+// descending ID order. It is the exact reverse of the ascending result, for
+// consumers that append results and want the best match at the end.
+func (h *HNSW) KNNSearchDesc(q models.Element, K, ef int) []int {
+	res := h.KNNSearchWithEf(q, K, ef)
+	slices.Reverse(res)
+	return res
+}
+
+// KNNSearchEach calls fn for each of the K approximate nearest neighbors of
+// q in ascending distance order, stopping early when fn returns false. A
+// result is only final once the layer 0 search has ended, since a closer
+// node can turn up until then, so the calls start after the search; early
+// termination saves the consumer's work, not the search.
+func (h *HNSW) KNNSearchEach(q models.Element, K, ef int, fn func(models.Candidate) bool) {
+	for _, c := range h.searchKNN(q, K, ef, nil) {
+		if !fn(c) {
+			return
+		}
+	}
+}
+
+// KNNSearchRerank over-fetches overfetch*K candidates with the graph metric
+// and returns the K best of them under the exact distance set by
+// WithRefineDistance. The search ef is raised to overfetch*K if smaller.
+// Plain searches already re-rank all ef candidates when a refine distance
+// is set; KNNSearchRerank sizes the re-ranked pool by overfetch instead, so
+// a wide search can be paired with a small exact pass. Without a refine
+// distance the metric is already exact and the result matches
+// KNNSearchWithEf.
+//
+// Recall rises with overfetch until the pool holds the true neighbors; the
+// coarser the metric, the larger the pool needed. Latency grows with it
+// twice: through the larger search ef and through overfetch*K exact
+// distance evaluations, which dominate when the exact distance is costly.
+func (h *HNSW) KNNSearchRerank(q models.Element, K, ef, overfetch int) []int {
+	n := K * max(overfetch, 1)
+	// A caller-supplied distance keeps searchKNN from re-ranking itself.
+	pool := h.searchKNN(q, n, max(ef, n), &layerSearch{dist: h.queryDistance(q)})
+	if h.refine != nil {
+		dist := h.refineQuery(q)
+		for i := range pool {
+			pool[i].Distance = dist(pool[i].NodeID)
+		}
+		sortCandidates(pool)
+	}
+	return candidateIDs(pool[:min(len(pool), K)])
+}
+
+// KNNSearchDiverse returns up to K neighbors of q that lie at least minDist
+// apart from each other under the index metric. It runs a normal search
+// for the max(ef, K) nearest elements and selects them greedily in order
+// of distance to q, skipping any within minDist of one already selected.
+// Fewer than K IDs are returned when the ef candidates are too clustered;
+// raise ef to draw from a wider pool.
+func (h *HNSW) KNNSearchDiverse(q models.Element, K, ef int, minDist float64) []int {
+	res := make([]int, 0, K)
+	for _, c := range h.searchKNN(q, max(ef, K), ef, nil) {
+		if len(res) == K {
+			break
+		}
+		spaced := true
+		for _, id := range res {
+			if h.nodeDistance(c.NodeID, id) < minDist {
+				spaced = false
+				break
+			}
+		}
+		if spaced {
+			res = append(res, c.NodeID)
+		}
+	}
+	return res
+}
+
+// searchDistinct returns the closest element for each of the K nearest
+// distinct keys. The search ef is doubled until K keys are found or every
+// element has been considered. key is given the element with its vector.
+func searchDistinct[T comparable](h *HNSW, q models.Element, K, ef int, key func(models.Element) T) []models.Candidate {
+	if K <= 0 {
+		return nil
+	}
+	ef = max(ef, K, 1)
+	for {
+		res := make([]models.Candidate, 0, K)
+		seen := make(map[T]bool)
+		// Candidates are sorted, so the first element seen per key is its best.
+		for _, c := range h.searchKNN(q, ef, ef, nil) {
+			k := key(h.element(c.NodeID))
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			res = append(res, c)
+			if len(res) == K {
+				return res
+			}
+		}
+		if ef >= len(h.Elements) {
+			return res
+		}
+		ef *= 2
+	}
+}
+
+// KNNSearchMaxDist is KNNSearchWithEf limited to elements within maxDist of
+// q, boundary included, so it returns fewer than K results when fewer lie
+// that close. maxDist is in Distance units, as the radius of RangeSearch.
+// Unlike RangeSearch, which returns every element in range, it returns at
+// most K and costs one search.
+//
+// The search itself is not cut short at maxDist, since paths through
+// farther nodes still lead to nearer ones.
+func (h *HNSW) KNNSearchMaxDist(q models.Element, K, ef int, maxDist float64) []int {
+	res := h.searchKNN(q, K, ef, nil)
+	n := 0
+	for n < len(res) && res[n].Distance <= maxDist {
+		n++
+	}
+	return candidateIDs(res[:n])
+}
+
+// RangeSearch returns every live element within radius of q, sorted by ascending
+// distance. The radius is in the same units as Distance and the boundary is
+// inclusive (distance <= radius):
+//
+//   - L2: Euclidean distance, not squared; it is compared as
+//     squared distance <= radius*radius internally.
+//   - Cosine: 1 - cosine similarity, so radius 0.2 means similarity >= 0.8.
+//   - Angular: angle in radians, so radius π/6 means at most 30 degrees apart.
+//   - InnerProduct: negative dot product, so radius -0.8 means dot >= 0.8.
+//
+// The ef nearest elements seed a breadth-first expansion over layer 0 that
+// follows neighbors while they stay in range.
+func (h *HNSW) RangeSearch(q models.Element, radius float64, ef int) []models.Candidate {
+	seeds := h.searchKNN(q, ef, ef, nil)
+	dist := h.queryDistance(q)
+	visited := make(map[int]bool, len(seeds))
+	var res, queue []models.Candidate
+	for _, c := range seeds {
+		visited[c.NodeID] = true
+		if d, ok := h.withinRadius(q, dist, c.NodeID, radius); ok {
+			c.Distance = d
+			res = append(res, c)
+			queue = append(queue, c)
+		}
+	}
+	// Deleted and hidden nodes in range are expanded but not returned.
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, n := range h.Layers[0][c.NodeID].Candidates {
+			if visited[n.NodeID] {
+				continue
+			}
+			visited[n.NodeID] = true
+			if d, ok := h.withinRadius(q, dist, n.NodeID, radius); ok {
+				found := models.Candidate{NodeID: n.NodeID, Distance: d}
+				if h.searchable(n.NodeID) {
+					res = append(res, found)
+				}
+				queue = append(queue, found)
+			}
+		}
+	}
+	sortCandidates(res)
+	return res
+}
+
+// withinRadius reports whether element id lies within radius of q, and if
+// so its distance. dist is the query distance function of q.
+func (h *HNSW) withinRadius(q models.Element, dist func(int) float64, id int, radius float64) (float64, bool) {
+	if h.Metric == L2 || h.Metric == "" {
+		if radius < 0 {
+			return 0, false
+		}
+		sq := h.squaredL2(q.Embeddings, h.vector(id))
+		if sq > radius*radius {
+			return 0, false
+		}
+		return math.Sqrt(sq), true
+	}
+	d := dist(id)
+	return d, d <= radius
+}
+
+// ExactMatch looks for a live element whose vector equals vec within the
+// Euclidean tolerance set by WithExactMatchEpsilon (0 by default) and
+// returns its ID. The layer 0 search stops at the first match, so lookups
+// of vectors that are present are cheap. Like any graph search it can miss
+// a match, most likely with InnerProduct, where a vector is not its own
+// nearest neighbor.
+func (h *HNSW) ExactMatch(vec []float64) (int, bool) {
+	return h.exactMatch(vec, h.exactEpsilon)
+}
+
+// exactMatch is ExactMatch with tolerance eps.
+func (h *HNSW) exactMatch(vec []float64, eps float64) (int, bool) {
+	q := models.Element{Embeddings: vec}
+	opts := &layerSearch{match: func(id int, d float64) bool {
+		if h.Metric != L2 && h.Metric != "" {
+			d = math.Sqrt(h.squaredL2(vec, h.vector(id)))
+		}
+		return d <= eps
+	}}
+	h.searchKNN(q, 1, h.EfConstruction, opts)
+	if !opts.found {
+		return -1, false
+	}
+	return opts.matched, true
+}
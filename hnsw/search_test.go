@@ -0,0 +1,381 @@
+package hnsw
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+func TestKNNSearchEmpty(t *testing.T) {
+	q := models.Element{Embeddings: []float64{1, 2}}
+	h := NewHNSW(10, 4, 3, 0.5)
+	check := func(state string) {
+		t.Helper()
+		if res := h.KNNSearch(q, 3); len(res) != 0 {
+			t.Errorf("%s: KNNSearch = %v, want none", state, res)
+		}
+		if res := h.KNNSearchWithEf(q, 3, 10); len(res) != 0 {
+			t.Errorf("%s: KNNSearchWithEf = %v, want none", state, res)
+		}
+		if res := h.RangeSearch(q, 10, 10); len(res) != 0 {
+			t.Errorf("%s: RangeSearch = %v, want none", state, res)
+		}
+	}
+	check("new index")
+
+	var buf bytes.Buffer
+	if err := h.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	h = loaded
+	check("loaded empty index")
+
+	if err := h.Insert(models.Element{ID: 7, Embeddings: []float64{1, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Delete(7); err != nil {
+		t.Fatal(err)
+	}
+	check("only element deleted")
+	h.Compact()
+	check("only element compacted")
+}
+
+func TestKNNSearchByIDExcludesSelf(t *testing.T) {
+	const K = 10
+	h := buildRandom(t, 500, 8, 10)
+	for id := 0; id < 500; id += 37 {
+		res, err := h.KNNSearchByID(id, K, 20)
+		if err != nil {
+			t.Fatalf("KNNSearchByID(%d): %v", id, err)
+		}
+		if len(res) != K {
+			t.Fatalf("KNNSearchByID(%d) = %d results, want %d", id, len(res), K)
+		}
+		seen := map[int]bool{id: true}
+		for _, n := range res {
+			if seen[n] {
+				t.Fatalf("KNNSearchByID(%d) = %v, holds %d twice or the query", id, res, n)
+			}
+			seen[n] = true
+		}
+		with, err := h.KNNSearchByIDExclude(id, K, 20, false)
+		if err != nil {
+			t.Fatalf("KNNSearchByIDExclude(%d): %v", id, err)
+		}
+		if len(with) != K || with[0] != id {
+			t.Errorf("KNNSearchByIDExclude(%d, false) = %v, want %d first", id, with, id)
+		}
+	}
+	if _, err := h.KNNSearchByID(-1, K, 20); err == nil {
+		t.Error("KNNSearchByID of a missing element succeeded")
+	}
+}
+
+// rangeIndex returns an index of 2-d elements under metric m.
+func rangeIndex(t *testing.T, m Metric, vectors ...[]float64) *HNSW {
+	t.Helper()
+	h := NewHNSW(20, 4, 3, 0.72, WithMetric(m))
+	for i, v := range vectors {
+		if err := h.Insert(models.Element{ID: i, Embeddings: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+// checkRange checks that RangeSearch at radius returns want, and that a
+// radius just below it drops the elements on the boundary.
+func checkRange(t *testing.T, h *HNSW, q []float64, radius float64, want, inside []int) {
+	t.Helper()
+	ids := func(res []models.Candidate) []int {
+		res = slices.Clone(res)
+		slices.SortFunc(res, func(a, b models.Candidate) int { return a.NodeID - b.NodeID })
+		return candidateIDs(res)
+	}
+	e := models.Element{Embeddings: q}
+	if got := ids(h.RangeSearch(e, radius, 10)); !slices.Equal(got, want) {
+		t.Errorf("RangeSearch(%v) = %v, want %v", radius, got, want)
+	}
+	below := math.Nextafter(radius, math.Inf(-1))
+	if got := ids(h.RangeSearch(e, below, 10)); !slices.Equal(got, inside) {
+		t.Errorf("RangeSearch(%v) = %v, want %v", below, got, inside)
+	}
+}
+
+func TestRangeSearchBoundaryL2(t *testing.T) {
+	// Distances 5, 5, 1 and 6 from the origin.
+	h := rangeIndex(t, L2, []float64{3, 4}, []float64{0, 5}, []float64{1, 0}, []float64{6, 0})
+	checkRange(t, h, []float64{0, 0}, 5, []int{0, 1, 2}, []int{2})
+}
+
+func TestRangeSearchBoundaryCosine(t *testing.T) {
+	// Distances 1, 0, 2 and 1 from (1, 0).
+	h := rangeIndex(t, Cosine, []float64{0, 1}, []float64{2, 0}, []float64{-1, 0}, []float64{0, -3})
+	checkRange(t, h, []float64{1, 0}, 1, []int{0, 1, 3}, []int{1})
+}
+
+func TestRangeSearchBoundaryAngular(t *testing.T) {
+	// Angles π/2, 0, π and π/2 from (1, 0).
+	h := rangeIndex(t, Angular, []float64{0, 1}, []float64{2, 0}, []float64{-1, 0}, []float64{0, -3})
+	checkRange(t, h, []float64{1, 0}, math.Pi/2, []int{0, 1, 3}, []int{1})
+}
+
+func TestRangeSearchBoundaryInnerProduct(t *testing.T) {
+	// Distances -2, -1, 0 and 1 from (1, 0).
+	h := rangeIndex(t, InnerProduct, []float64{2, 0}, []float64{1, 5}, []float64{0, 3}, []float64{-1, 1})
+	checkRange(t, h, []float64{1, 0}, -1, []int{0, 1}, []int{0})
+}
+
+func TestKNNSearchTimeRangeBoundaries(t *testing.T) {
+	const n = 200
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) time.Time { return base.Add(time.Duration(hour) * time.Hour) }
+	r := rand.New(rand.NewSource(21))
+	h := NewHNSW(50, 8, 5, 0.48, WithSeed(21))
+	for i := 0; i < n; i++ {
+		if err := h.Insert(models.Element{ID: i, Embeddings: randomVector(r, 4), CreatedAt: at(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q := models.Element{Embeddings: randomVector(r, 4)}
+	for _, tc := range []struct {
+		after, before time.Time
+		from, to      int // Expected IDs, inclusive
+	}{
+		{at(50), at(59), 50, 59},
+		{at(70), at(70), 70, 70},
+		{at(195), time.Time{}, 195, n - 1},
+		{time.Time{}, at(2), 0, 2},
+		{at(50).Add(time.Nanosecond), at(59).Add(-time.Nanosecond), 51, 58},
+	} {
+		got := h.KNNSearchTimeRange(q, n, n, tc.after, tc.before)
+		slices.Sort(got)
+		var want []int
+		for id := tc.from; id <= tc.to; id++ {
+			want = append(want, id)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("KNNSearchTimeRange(%v, %v) = %v, want %v", tc.after, tc.before, got, want)
+		}
+	}
+}
+
+// TestMaxDistBruteForce checks KNNSearchMaxDist and RangeSearch against a
+// scan: neither returns an element beyond the cap, and both find nearly
+// all of those within it.
+func TestMaxDistBruteForce(t *testing.T) {
+	const n, d, K, ef = 1000, 8, 10, 50
+	h := buildRandom(t, n, d, 23)
+	r := rand.New(rand.NewSource(24))
+	var found, inRange, rangeFound, rangeTotal int
+	for i := 0; i < 50; i++ {
+		q := models.Element{Embeddings: randomVector(r, d)}
+		dist := func(id int) float64 { return h.Distance(q, models.Element{Embeddings: h.vector(id)}) }
+		exact := h.BruteForceKNN(q, n)
+		// Cap at the distance of the 5th true neighbor, nudged off it so
+		// that rounding cannot decide membership.
+		maxDist := (dist(exact[4]) + dist(exact[5])) / 2
+		within := func(ids []int) []int {
+			j := 0
+			for j < len(ids) && dist(ids[j]) <= maxDist {
+				j++
+			}
+			return ids[:j]
+		}
+		want := within(exact[:K])
+		got := h.KNNSearchMaxDist(q, K, ef, maxDist)
+		for _, id := range got {
+			if dist(id) > maxDist {
+				t.Fatalf("KNNSearchMaxDist returned %d at %v, beyond %v", id, dist(id), maxDist)
+			}
+			if slices.Contains(want, id) {
+				found++
+			}
+		}
+		inRange += len(want)
+		// RangeSearch returns every element in range, not just K.
+		radius := (dist(exact[2*K]) + dist(exact[2*K+1])) / 2
+		var all []int
+		for _, c := range h.RangeSearch(q, radius, ef) {
+			if dist(c.NodeID) > radius {
+				t.Fatalf("RangeSearch returned %d at %v, beyond %v", c.NodeID, dist(c.NodeID), radius)
+			}
+			all = append(all, c.NodeID)
+		}
+		for _, id := range exact[:2*K+1] {
+			if slices.Contains(all, id) {
+				rangeFound++
+			}
+		}
+		rangeTotal += 2*K + 1
+	}
+	if recall := float64(found) / float64(inRange); recall < 0.95 {
+		t.Errorf("KNNSearchMaxDist: recall %.3f of in-range neighbors, want at least 0.95", recall)
+	}
+	if recall := float64(rangeFound) / float64(rangeTotal); recall < 0.95 {
+		t.Errorf("RangeSearch: recall %.3f, want at least 0.95", recall)
+	}
+}
+
+// TestKNNSearchMaxDistInclusive checks that a result at exactly maxDist is
+// kept.
+func TestKNNSearchMaxDistInclusive(t *testing.T) {
+	h := buildRandom(t, 500, 8, 25)
+	q := models.Element{Embeddings: randomVector(rand.New(rand.NewSource(26)), 8)}
+	var res []models.Candidate
+	h.KNNSearchEach(q, 10, 50, func(c models.Candidate) bool {
+		res = append(res, c)
+		return true
+	})
+	if got, want := h.KNNSearchMaxDist(q, 10, 50, res[4].Distance), candidateIDs(res[:5]); !slices.Equal(got, want) {
+		t.Errorf("KNNSearchMaxDist at the 5th distance = %v, want %v", got, want)
+	}
+}
+
+// TestKNNSearchDiverseSpacing builds clusters of five near-duplicates and
+// checks that diverse results are at least minDist apart, are the greedy
+// pick from the plain search results, and match them when minDist is 0.
+func TestKNNSearchDiverseSpacing(t *testing.T) {
+	const d, K, ef, minDist = 4, 10, 100, 0.05
+	r := rand.New(rand.NewSource(39))
+	h := NewHNSW(50, 8, 5, 0.48, WithSeed(39))
+	for c := 0; c < 100; c++ {
+		center := randomVector(r, d)
+		for j := 0; j < 5; j++ {
+			v := slices.Clone(center)
+			for i := range v {
+				v[i] += 0.001 * r.NormFloat64()
+			}
+			if err := h.Insert(models.Element{ID: 5*c + j, Embeddings: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dist := func(a, b int) float64 {
+		return h.Distance(models.Element{Embeddings: h.vector(a)}, models.Element{Embeddings: h.vector(b)})
+	}
+	for i := 0; i < 20; i++ {
+		q := models.Element{Embeddings: randomVector(r, d)}
+		got := h.KNNSearchDiverse(q, K, ef, minDist)
+		if len(got) != K {
+			t.Fatalf("got %d results, want %d", len(got), K)
+		}
+		for a := range got {
+			for b := a + 1; b < len(got); b++ {
+				if dd := dist(got[a], got[b]); dd < minDist {
+					t.Fatalf("results %d and %d are %v apart, want at least %v", got[a], got[b], dd, minDist)
+				}
+			}
+		}
+		var want []int
+		for _, id := range h.KNNSearchWithEf(q, ef, ef) {
+			if len(want) < K && !slices.ContainsFunc(want, func(w int) bool { return dist(id, w) < minDist }) {
+				want = append(want, id)
+			}
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("KNNSearchDiverse = %v, want the greedy pick %v", got, want)
+		}
+		if got, want := h.KNNSearchDiverse(q, K, ef, 0), h.KNNSearchWithEf(q, K, ef); !slices.Equal(got, want) {
+			t.Fatalf("KNNSearchDiverse(minDist 0) = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestKNNSearchDescReverse checks KNNSearchDesc against the reversed
+// ascending results, including tied distances from duplicate vectors and K
+// beyond the index size.
+func TestKNNSearchDescReverse(t *testing.T) {
+	const d = 4
+	h := buildRandom(t, 200, d, 40)
+	for id := 200; id < 210; id++ {
+		if err := h.Insert(models.Element{ID: id, Embeddings: []float64{0.5, 0.5, 0.5, 0.5}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := rand.New(rand.NewSource(41))
+	queries := []models.Element{{Embeddings: []float64{0.5, 0.5, 0.5, 0.5}}}
+	for i := 0; i < 20; i++ {
+		queries = append(queries, models.Element{Embeddings: randomVector(r, d)})
+	}
+	for _, q := range queries {
+		for _, K := range []int{0, 1, 5, 10, 300} {
+			asc := h.KNNSearchWithEf(q, K, 50)
+			got := h.KNNSearchDesc(q, K, 50)
+			slices.Reverse(asc)
+			if !slices.Equal(got, asc) {
+				t.Fatalf("K=%d: KNNSearchDesc = %v, want %v", K, got, asc)
+			}
+		}
+	}
+}
+
+// TestSortedResultsIdentical checks that layer searches return the same
+// results whether they keep them in a sorted slice or in a heap, and that
+// builds using either produce the same graph.
+func TestSortedResultsIdentical(t *testing.T) {
+	const heapOnly, sliceOnly = 1, 1 << 30
+	h := buildRandom(t, 1000, 8, 21, WithSortedResultsBelow(sliceOnly))
+	r := rand.New(rand.NewSource(22))
+	for _, ef := range []int{1, 10, 64, 200} {
+		for i := 0; i < 20; i++ {
+			q := models.Element{Embeddings: randomVector(r, 8)}
+			h.sortedBelow = sliceOnly
+			slice := h.SearchLayerCandidates(q, h.EnterPoint, ef, 0)
+			h.sortedBelow = heapOnly
+			if got := h.SearchLayerCandidates(q, h.EnterPoint, ef, 0); !slices.Equal(got, slice) {
+				t.Fatalf("ef %d: heap %v, sorted slice %v", ef, got, slice)
+			}
+		}
+	}
+	a, err := buildRandom(t, 1000, 8, 23, WithSortedResultsBelow(heapOnly)).GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := buildRandom(t, 1000, 8, 23, WithSortedResultsBelow(sliceOnly)).GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("builds with the heap and the sorted slice differ")
+	}
+}
+
+// BenchmarkSearchLayerSorted compares layer 0 searches keeping results in
+// the sorted slice and in the heap across ef.
+func BenchmarkSearchLayerSorted(b *testing.B) {
+	const n, d = 10000, 16
+	r := rand.New(rand.NewSource(1))
+	h := NewHNSW(100, 16, 6, 0.36, WithSeed(1))
+	for i := 0; i < n; i++ {
+		h.Insert(models.Element{ID: i, Embeddings: randomVector(r, d)})
+	}
+	queries := make([]models.Element, 100)
+	for i := range queries {
+		queries[i] = models.Element{Embeddings: randomVector(r, d)}
+	}
+	for _, ef := range []int{10, 64, 128, 160, 400} {
+		for _, sorted := range []bool{true, false} {
+			h.sortedBelow = 1
+			if sorted {
+				h.sortedBelow = 1 << 30
+			}
+			b.Run(fmt.Sprintf("ef=%d/sorted=%v", ef, sorted), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					h.searchLayer(queries[i%len(queries)], h.EnterPoint, ef, 0, nil)
+				}
+			})
+		}
+	}
+}
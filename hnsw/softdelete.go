@@ -0,0 +1,32 @@
+package hnsw
+
+import "fmt"
+
+// SoftDelete hides element id from searches without deleting it. Unlike
+// Delete, hiding is reversible with Undelete and never leads to the node
+// being compacted away, so there is no re-linking cost either way. Hidden
+// elements still count as stored: Get, labels and Delete work on them.
+func (h *HNSW) SoftDelete(id int) error {
+	if _, ok := h.Get(id); !ok {
+		return fmt.Errorf("element %d: %w", id, ErrNotFound)
+	}
+	h.Hidden[id] = true
+	return nil
+}
+
+// Undelete makes an element hidden by SoftDelete searchable again. It
+// returns ErrNotFound if id is not hidden.
+func (h *HNSW) Undelete(id int) error {
+	if !h.Hidden[id] {
+		return fmt.Errorf("element %d: %w", id, ErrNotFound)
+	}
+	delete(h.Hidden, id)
+	return nil
+}
+
+// searchable reports whether element id is stored, not deleted and not
+// hidden, i.e. whether searches may return it.
+func (h *HNSW) searchable(id int) bool {
+	_, ok := h.Elements[id]
+	return ok && !h.Deleted[id] && !h.Hidden[id]
+}
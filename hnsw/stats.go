@@ -0,0 +1,42 @@
+package hnsw
+
+// Stats summarizes the shape of an index.
+type Stats struct {
+	Elements       int     // Number of stored elements, including deleted ones
+	Deleted        int     // Number of deleted elements awaiting Compact
+	TombstoneRatio float64 // Deleted / Elements, the share of hops wasted on deleted nodes
+	Hidden         int     // Number of elements hidden by SoftDelete
+	Layers         int     // Number of layers
+	LayerSizes     []int   // Elements present at each layer, layer 0 first
+	EnterPoint     int     // Entry point ID, -1 when empty
+	Dim            int     // Vector dimension queries must have; 0 until fixed
+	Metric         Metric  // Distance function, L2 when not set explicitly
+}
+
+// Stats returns a summary of the index. Layer populations are derived from
+// the level of each element: an element at level l is present in layers 0
+// through l. Dim and Metric let a client check that its queries match an
+// index, e.g. one loaded from disk, before sending them.
+func (h *HNSW) Stats() Stats {
+	metric := h.Metric
+	if metric == "" {
+		metric = L2
+	}
+	sizes := make([]int, len(h.Layers))
+	for _, level := range h.Levels {
+		for lc := 0; lc <= level && lc < len(sizes); lc++ {
+			sizes[lc]++
+		}
+	}
+	return Stats{
+		Elements:       len(h.Elements),
+		Deleted:        len(h.Deleted),
+		TombstoneRatio: h.tombstoneRatio(),
+		Hidden:         len(h.Hidden),
+		Layers:         len(h.Layers),
+		LayerSizes:     sizes,
+		EnterPoint:     h.EnterPoint,
+		Dim:            h.Dim,
+		Metric:         metric,
+	}
+}
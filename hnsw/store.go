@@ -1,25 +1,258 @@
 package hnsw
 
 import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
 )
 
-func JsonStructLocalStore(val interface{}, filePath string) error {
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+// ErrCorrupt is returned when a saved index fails its checksum.
+var ErrCorrupt = errors.New("hnsw: corrupt index")
+
+// checksumMagic starts every gob encoding that carries a checksum. It is
+// followed by the encoded gobIndex and the CRC-32 (IEEE) of it, big-endian.
+// Encodings written before checksums were added start with a gob message
+// length instead and are decoded unverified.
+var checksumMagic = []byte("HNC1")
+
+// gobIndex is the gob form of an HNSW graph. Maps are flattened into slices
+// sorted by node ID, because gob writes maps in random order and identical
+// graphs must encode to identical bytes. Vectors are stored once, in
+// Elements; connections are stored as neighbor IDs and their distances are
+// recomputed on decode.
+type gobIndex struct {
+	EnterPoint      int
+	M               int
+	EfConstruction  int
+	DefaultEf       int
+	NormalizationML float64
+	MaxLayers       int
+	Metric          Metric
+	Dim             int
+	Elements        []models.Element
+	IntVectors      [][]int32 // Parallel to Elements, integer metrics only
+	Float16         bool
+	HalfVectors     [][]uint16 // Parallel to Elements, Float16 only
+	Kahan           bool
+	Deleted         []int
+	Hidden          []int
+	Layers          []gobLayer
+	Labels          []gobLabel
+	Metadata        []gobMeta
+}
+
+// gobLayer holds one layer's connections, Neighbors[i] belonging to NodeIDs[i].
+type gobLayer struct {
+	NodeIDs   []int
+	Neighbors [][]int
+}
+
+// gobLabel is one entry of HNSW.Labels.
+type gobLabel struct {
+	ID    int
+	Label int
+}
+
+// gobMeta is one entry of HNSW.Metadata.
+type gobMeta struct {
+	Key   string
+	Value string
+}
+
+// GobEncode implements gob.GobEncoder with a deterministic encoding.
+func (h *HNSW) GobEncode() ([]byte, error) {
+	return h.encode(false)
+}
+
+// encode returns the checksummed gob encoding of the index. With
+// embedVectors, elements whose vectors a VectorStore serves are written
+// with their vectors, so the encoding decodes to a self-contained index.
+func (h *HNSW) encode(embedVectors bool) ([]byte, error) {
+	idx := gobIndex{
+		EnterPoint:      h.EnterPoint,
+		M:               h.M,
+		EfConstruction:  h.EfConstruction,
+		DefaultEf:       h.DefaultEf,
+		NormalizationML: h.NormalizationML,
+		MaxLayers:       h.MaxLayers,
+		Metric:          h.Metric,
+		Dim:             h.Dim,
+		Float16:         h.Float16,
+		Kahan:           h.Kahan,
+		Elements:        make([]models.Element, 0, len(h.Elements)),
+		Deleted:         sortedKeys(h.Deleted),
+		Hidden:          sortedKeys(h.Hidden),
+		Layers:          make([]gobLayer, len(h.Layers)),
+		Labels:          make([]gobLabel, 0, len(h.Labels)),
+		Metadata:        sortedMeta(h.Metadata),
+	}
+	for _, id := range sortedKeys(h.Elements) {
+		e := h.Elements[id]
+		if embedVectors && h.vectorStore != nil && !h.Metric.isInt() {
+			e.Embeddings = h.vectorStore.Get(id)
+		}
+		idx.Elements = append(idx.Elements, e)
+		if h.Metric.isInt() {
+			idx.IntVectors = append(idx.IntVectors, h.IntVectors[id])
+		}
+		if h.Float16 {
+			idx.HalfVectors = append(idx.HalfVectors, h.HalfVectors[id])
+		}
+	}
+	for _, id := range sortedKeys(h.Labels) {
+		idx.Labels = append(idx.Labels, gobLabel{ID: id, Label: h.Labels[id]})
+	}
+	for lc, layer := range h.Layers {
+		ids := sortedKeys(layer)
+		neighbors := make([][]int, len(ids))
+		for i, id := range ids {
+			neighbors[i] = layer[id].ExtractHeapData()
+		}
+		idx.Layers[lc] = gobLayer{NodeIDs: ids, Neighbors: neighbors}
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(val); err != nil {
+	var buf bytes.Buffer
+	buf.Write(checksumMagic)
+	if err := gob.NewEncoder(&buf).Encode(&idx); err != nil {
+		return nil, err
+	}
+	sum := crc32.ChecksumIEEE(buf.Bytes()[len(checksumMagic):])
+	return binary.BigEndian.AppendUint32(buf.Bytes(), sum), nil
+}
+
+// GobDecode implements gob.GobDecoder. It fails with ErrCorrupt if the
+// checksum does not match.
+func (h *HNSW) GobDecode(data []byte) error {
+	if bytes.HasPrefix(data, checksumMagic) {
+		data = data[len(checksumMagic):]
+		if len(data) < 4 {
+			return fmt.Errorf("hnsw: truncated encoding: %w", ErrCorrupt)
+		}
+		payload, stored := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+		if sum := crc32.ChecksumIEEE(payload); sum != stored {
+			return fmt.Errorf("hnsw: checksum %08x, stored %08x: %w", sum, stored, ErrCorrupt)
+		}
+		data = payload
+	}
+	var idx gobIndex
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&idx); err != nil {
 		return err
 	}
+	h.EnterPoint = idx.EnterPoint
+	h.M = idx.M
+	h.maxConnections = 2 * idx.M
+	h.EfConstruction = idx.EfConstruction
+	h.DefaultEf = idx.DefaultEf
+	h.NormalizationML = idx.NormalizationML
+	h.MaxLayers = idx.MaxLayers
+	h.Metric = idx.Metric
+	h.Dim = idx.Dim
+	h.Float16 = idx.Float16
+	h.Kahan = idx.Kahan
+	h.Elements = make(map[int]models.Element, len(idx.Elements))
+	h.IntVectors = make(map[int][]int32, len(idx.IntVectors))
+	h.HalfVectors = make(map[int][]uint16, len(idx.HalfVectors))
+	for i, e := range idx.Elements {
+		h.Elements[e.ID] = e
+		if i < len(idx.IntVectors) {
+			h.IntVectors[e.ID] = idx.IntVectors[i]
+		}
+		if i < len(idx.HalfVectors) {
+			h.HalfVectors[e.ID] = idx.HalfVectors[i]
+		}
+	}
+	h.Deleted = make(map[int]bool, len(idx.Deleted))
+	for _, id := range idx.Deleted {
+		h.Deleted[id] = true
+	}
+	h.Hidden = make(map[int]bool, len(idx.Hidden))
+	for _, id := range idx.Hidden {
+		h.Hidden[id] = true
+	}
+	h.Labels = make(map[int]int, len(idx.Labels))
+	for _, l := range idx.Labels {
+		h.Labels[l.ID] = l.Label
+	}
+	h.Metadata = nil
+	if len(idx.Metadata) > 0 {
+		h.Metadata = make(map[string]string, len(idx.Metadata))
+		for _, m := range idx.Metadata {
+			h.Metadata[m.Key] = m.Value
+		}
+	}
+	h.Layers = make([]map[int]*hnswheap.CandidateHeap, len(idx.Layers))
+	for lc, l := range idx.Layers {
+		layer := make(map[int]*hnswheap.CandidateHeap, len(l.NodeIDs))
+		for i, id := range l.NodeIDs {
+			conns := hnswheap.NewBigCandidatesHeap()
+			for _, n := range l.Neighbors[i] {
+				heap.Push(conns, models.Candidate{
+					NodeID:   n,
+					Distance: h.nodeDistance(id, n),
+				})
+			}
+			layer[id] = conns
+		}
+		h.Layers[lc] = layer
+	}
 	return nil
 }
 
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// sortedMeta flattens m into entries sorted by key.
+func sortedMeta(m map[string]string) []gobMeta {
+	res := make([]gobMeta, 0, len(m))
+	for k, v := range m {
+		res = append(res, gobMeta{Key: k, Value: v})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Save writes the index to w in the compact gob format.
+func (h *HNSW) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(h)
+}
+
+// Load reads an index written by Save.
+func Load(r io.Reader) (*HNSW, error) {
+	var res = HNSW{}
+	if err := gob.NewDecoder(r).Decode(&res); err != nil {
+		return nil, err
+	}
+	res.restore()
+	return &res, nil
+}
+
+// JsonStructLocalStore writes val to filePath as JSON, atomically replacing
+// any previous file.
+func JsonStructLocalStore(val interface{}, filePath string) error {
+	return writeFileAtomic(filePath, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(val)
+	})
+}
+
 func JsonReadStruct(filePath string) (*HNSW, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -32,34 +265,124 @@ func JsonReadStruct(filePath string) (*HNSW, error) {
 	if err := decoder.Decode(&res); err != nil {
 		return nil, err
 	}
+	res.restore()
 	return &res, nil
 }
 
+// legacyHNSW has the fields of HNSW but not its GobEncode and GobDecode
+// methods, so gob encodes it field by field, as GobStructLocalStore wrote
+// every index before HNSW implemented gob.GobEncoder.
+type legacyHNSW HNSW
+
+// GobStructLocalStore writes val to filePath as gob, atomically replacing
+// any previous file. An *HNSW is written field by field, in the format
+// GobReadStruct reads; use Save for the compact format.
 func GobStructLocalStore(val interface{}, filePath string) error {
-	file, err := os.Create(filePath)
+	if h, ok := val.(*HNSW); ok {
+		val = (*legacyHNSW)(h)
+	}
+	return writeFileAtomic(filePath, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(val)
+	})
+}
+
+// writeFileAtomic passes write a temporary file in the directory of path
+// and, once it is written and synced to disk, renames it to path. A failed
+// or interrupted save thus leaves any previous file at path intact; only
+// a stray temporary file may remain after a crash. A new file gets mode
+// 0644, a replaced one keeps its mode.
+func writeFileAtomic(path string, write func(io.Writer) error) (err error) {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(val); err != nil {
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	if err := write(file); err != nil {
 		return err
 	}
-	return nil
+	if err := file.Chmod(mode); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
 }
 
+// GobReadStruct reads an index written by GobStructLocalStore. Files that
+// hold the compact encoding of Save, which GobStructLocalStore wrote for a
+// while, are read as well.
 func GobReadStruct(filePath string) (*HNSW, error) {
-	file, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
 	var res = HNSW{}
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&res); err != nil {
-		return nil, err
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode((*legacyHNSW)(&res)); err != nil {
+		res = HNSW{}
+		if gob.NewDecoder(bytes.NewReader(data)).Decode(&res) != nil {
+			return nil, err
+		}
 	}
+	res.restore()
 	return &res, nil
 }
+
+// restore rebuilds the state that decoding does not guarantee: the heap
+// invariant of every connection heap, element levels, cached norms, the
+// label index, the entry point, the dimension and derived limits.
+func (h *HNSW) restore() {
+	if h.maxConnections == 0 {
+		h.maxConnections = 2 * h.M
+	}
+	if h.Elements == nil {
+		h.Elements = make(map[int]models.Element)
+	}
+	if h.Deleted == nil {
+		h.Deleted = make(map[int]bool)
+	}
+	if h.Hidden == nil {
+		h.Hidden = make(map[int]bool)
+	}
+	if h.IntVectors == nil {
+		h.IntVectors = make(map[int][]int32)
+	}
+	if h.HalfVectors == nil {
+		h.HalfVectors = make(map[int][]uint16)
+	}
+	if h.Dim == 0 && len(h.Elements) > 0 {
+		// Written before the dimension was stored.
+		h.Dim = len(h.vector(sortedKeys(h.Elements)[0]))
+	}
+	if h.Labels == nil {
+		h.Labels = make(map[int]int)
+	}
+	h.rebuildLabelIDs()
+	h.rebuildNorms()
+	rebuildLevels := h.Levels == nil
+	if rebuildLevels {
+		h.Levels = make(map[int]int, len(h.Elements))
+	}
+	for lc, layer := range h.Layers {
+		for id, conns := range layer {
+			conns.Fix()
+			if rebuildLevels {
+				h.Levels[id] = lc
+			}
+		}
+	}
+	h.repairEnterPoint()
+}
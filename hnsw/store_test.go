@@ -0,0 +1,216 @@
+package hnsw
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// testdata/baseline.gob holds a 40-element index written by
+// GobStructLocalStore before HNSW implemented gob.GobEncoder.
+func TestGobReadStructBaseline(t *testing.T) {
+	h, err := GobReadStruct(filepath.Join("testdata", "baseline.gob"))
+	if err != nil {
+		t.Fatalf("GobReadStruct: %v", err)
+	}
+	if len(h.Elements) != 40 || h.EnterPoint != 29 || len(h.Layers) != 3 {
+		t.Fatalf("got %d elements, entry point %d, %d layers; want 40, 29, 3",
+			len(h.Elements), h.EnterPoint, len(h.Layers))
+	}
+	if h.Dim != 4 {
+		t.Errorf("Dim = %d, want 4", h.Dim)
+	}
+	q := models.Element{Embeddings: []float64{0.5, 0.5, 0.5, 0.5}}
+	if got, want := h.KNNSearchWithEf(q, 5, 40), h.BruteForceKNN(q, 5); !slices.Equal(got, want) {
+		t.Errorf("KNNSearchWithEf = %v, want %v", got, want)
+	}
+	if err := h.Insert(models.Element{ID: 40, Embeddings: []float64{0.5, 0.5, 0.5, 0.5}}); err != nil {
+		t.Fatalf("Insert after load: %v", err)
+	}
+	if got := h.KNNSearch(q, 1); !slices.Equal(got, []int{40}) {
+		t.Errorf("KNNSearch after insert = %v, want [40]", got)
+	}
+}
+
+func TestGobStructLocalStoreRoundTrip(t *testing.T) {
+	h := buildRandom(t, 200, 4, 3)
+	path := filepath.Join(t.TempDir(), "index.gob")
+	if err := GobStructLocalStore(h, path); err != nil {
+		t.Fatalf("GobStructLocalStore: %v", err)
+	}
+	res, err := GobReadStruct(path)
+	if err != nil {
+		t.Fatalf("GobReadStruct: %v", err)
+	}
+	q := models.Element{Embeddings: []float64{0.2, 0.4, 0.6, 0.8}}
+	if got, want := res.KNNSearch(q, 10), h.KNNSearch(q, 10); !slices.Equal(got, want) {
+		t.Errorf("KNNSearch after round trip = %v, want %v", got, want)
+	}
+}
+
+// GobStructLocalStore wrote the compact encoding while HNSW implemented
+// gob.GobEncoder and the helper did not bypass it.
+func TestGobReadStructCompact(t *testing.T) {
+	h := buildRandom(t, 200, 4, 4)
+	path := filepath.Join(t.TempDir(), "index.gob")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(f).Encode(h); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := GobReadStruct(path)
+	if err != nil {
+		t.Fatalf("GobReadStruct: %v", err)
+	}
+	q := models.Element{Embeddings: []float64{0.2, 0.4, 0.6, 0.8}}
+	if got, want := res.KNNSearch(q, 10), h.KNNSearch(q, 10); !slices.Equal(got, want) {
+		t.Errorf("KNNSearch after round trip = %v, want %v", got, want)
+	}
+}
+
+// The encoding Save writes ends with the checksummed index, so flipping a
+// byte there, the checksum included, must be caught.
+// TestSaveSize compares Save with the field-by-field gob encoding of
+// GobStructLocalStore and with JSON. Save stores each vector once and
+// connections without distances.
+func TestSaveSize(t *testing.T) {
+	h := buildRandom(t, 1000, 32, 27)
+	var compact, legacy bytes.Buffer
+	if err := h.Save(&compact); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := gob.NewEncoder(&legacy).Encode((*legacyHNSW)(h)); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	js, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	t.Logf("Save %d bytes, field-by-field gob %d, JSON %d", compact.Len(), legacy.Len(), len(js))
+	if compact.Len() > legacy.Len()*3/4 {
+		t.Errorf("Save wrote %d bytes, want at most 3/4 of the field-by-field %d", compact.Len(), legacy.Len())
+	}
+	if compact.Len() >= len(js) {
+		t.Errorf("Save wrote %d bytes, want fewer than JSON's %d", compact.Len(), len(js))
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	h := buildRandom(t, 100, 4, 6)
+	var buf bytes.Buffer
+	if err := h.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data := buf.Bytes()
+	if _, err := Load(bytes.NewReader(data)); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, pos := range []int{len(data) / 2, len(data) - 10, len(data) - 1} {
+		corrupt := bytes.Clone(data)
+		corrupt[pos] ^= 0x10
+		if _, err := Load(bytes.NewReader(corrupt)); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("byte %d of %d flipped: Load error %v, want ErrCorrupt", pos, len(data), err)
+		}
+	}
+}
+
+func TestLoadIndexCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.hnsw")
+	if err := buildRandom(t, 100, 4, 7).SaveIndex(path); err != nil {
+		t.Fatalf("SaveIndex: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0x01
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIndex(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("LoadIndex error %v, want ErrCorrupt", err)
+	}
+}
+
+// checkHeapsPop fails unless popping every connection heap of h yields
+// its candidates farthest first. It empties the heaps.
+func checkHeapsPop(t *testing.T, h *HNSW, loader string) {
+	t.Helper()
+	for lc, layer := range h.Layers {
+		for id, conns := range layer {
+			prev := 0.0
+			for i := 0; conns.Len() > 0; i++ {
+				c := heap.Pop(conns).(models.Candidate)
+				if i > 0 && c.Distance > prev {
+					t.Fatalf("%s: node %d layer %d: popped %v after %v", loader, id, lc, c.Distance, prev)
+				}
+				prev = c.Distance
+			}
+		}
+	}
+}
+
+func TestLoadedHeapsPopSorted(t *testing.T) {
+	h := buildRandom(t, 300, 4, 11)
+	dir := t.TempDir()
+	// Scramble every heap so that only a re-established invariant can
+	// pass. The loaders do not reorder the stored candidates.
+	for _, layer := range h.Layers {
+		for _, conns := range layer {
+			slices.SortFunc(conns.Candidates, func(a, b models.Candidate) int {
+				return cmp.Compare(a.Distance, b.Distance)
+			})
+		}
+	}
+	loaders := map[string]func() (*HNSW, error){
+		"Load": func() (*HNSW, error) {
+			var buf bytes.Buffer
+			if err := h.Save(&buf); err != nil {
+				return nil, err
+			}
+			return Load(&buf)
+		},
+		"GobReadStruct": func() (*HNSW, error) {
+			path := filepath.Join(dir, "index.gob")
+			if err := GobStructLocalStore(h, path); err != nil {
+				return nil, err
+			}
+			return GobReadStruct(path)
+		},
+		"JsonReadStruct": func() (*HNSW, error) {
+			path := filepath.Join(dir, "index.json")
+			if err := JsonStructLocalStore(h, path); err != nil {
+				return nil, err
+			}
+			return JsonReadStruct(path)
+		},
+		"LoadIndex": func() (*HNSW, error) {
+			path := filepath.Join(dir, "index.hnsw")
+			if err := h.SaveIndex(path); err != nil {
+				return nil, err
+			}
+			return LoadIndex(path)
+		},
+	}
+	for name, load := range loaders {
+		res, err := load()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkHeapsPop(t, res, name)
+	}
+}
@@ -0,0 +1,84 @@
+package hnsw
+
+import (
+	"container/heap"
+
+	"github.com/lblclass/hnswgo/models"
+	hnswheap "github.com/lblclass/hnswgo/util/heap"
+)
+
+// EdgeSymmetryReport counts the directed connections of all layers whose
+// reverse connection exists (symmetric) and those without one
+// (asymmetric). Inserts link both ways, but a full connection list drops
+// its farthest entry when a closer one arrives, which leaves the other
+// direction one-way; a high asymmetric count means many such evictions.
+func (h *HNSW) EdgeSymmetryReport() (symmetric, asymmetric int) {
+	for _, layer := range h.Layers {
+		for id, conns := range layer {
+			for _, c := range conns.Candidates {
+				if hasConnection(layer[c.NodeID], id) {
+					symmetric++
+				} else {
+					asymmetric++
+				}
+			}
+		}
+	}
+	return symmetric, asymmetric
+}
+
+// RepairSymmetry makes connections two-way. For each one-way edge the
+// reverse edge is added if the target has a free slot; otherwise the edge
+// is removed, except that a node keeps its closest one-way edge when it
+// would otherwise be left without connections. Edges to nodes missing from
+// the layer are always removed. It returns the number of edges added and
+// removed. Compact runs it after re-linking when the index was created
+// WithSymmetricRepair.
+func (h *HNSW) RepairSymmetry() (added, removed int) {
+	for _, layer := range h.Layers {
+		for _, id := range sortedKeys(layer) {
+			conns := layer[id]
+			kept := make([]models.Candidate, 0, conns.Len())
+			var oneWay []models.Candidate
+			for _, c := range conns.Candidates {
+				target, ok := layer[c.NodeID]
+				if !ok {
+					removed++
+					continue
+				}
+				if !hasConnection(target, id) {
+					if target.Len() >= h.maxConnections {
+						oneWay = append(oneWay, c)
+						continue
+					}
+					heap.Push(target, models.Candidate{NodeID: id, Distance: c.Distance})
+					added++
+				}
+				kept = append(kept, c)
+			}
+			if len(kept) == 0 && len(oneWay) > 0 {
+				sortCandidates(oneWay)
+				kept = append(kept, oneWay[0])
+				oneWay = oneWay[1:]
+			}
+			removed += len(oneWay)
+			conns.Candidates = kept
+			conns.Fix()
+		}
+	}
+	return added, removed
+}
+
+// hasConnection reports whether conns, which may be nil, holds a
+// connection to id.
+func hasConnection(conns *hnswheap.CandidateHeap, id int) bool {
+	if conns == nil {
+		return false
+	}
+	for _, c := range conns.Candidates {
+		if c.NodeID == id {
+			return true
+		}
+	}
+	return false
+}
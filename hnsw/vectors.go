@@ -0,0 +1,69 @@
+package hnsw
+
+import "github.com/lblclass/hnswgo/models"
+
+// ExportVectors returns a copy of every live element, including Msg,
+// GroupID and Payload, in ascending ID order. The graph itself is not exported; pass
+// the result to ImportVectors to rebuild it, possibly with other parameters.
+func (h *HNSW) ExportVectors() []models.Element {
+	res := make([]models.Element, 0, h.Len())
+	for _, id := range sortedKeys(h.Elements) {
+		if h.Deleted[id] {
+			continue
+		}
+		e := h.element(id)
+		if !h.Float16 {
+			e.Embeddings = append([]float64(nil), e.Embeddings...)
+		}
+		if e.Payload != nil {
+			e.Payload = append([]byte(nil), e.Payload...)
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// ImportVectors replaces the contents of the index with a fresh graph built
+// from elems, in order. The index configuration is kept. On error the
+// index holds the elements before the rejected one.
+func (h *HNSW) ImportVectors(elems []models.Element) error {
+	h.clear()
+	return h.InsertBatch(elems)
+}
+
+// Reset empties the index so the object can be reused, e.g. across
+// benchmark iterations, and leaves it in the state NewHNSW created with the
+// same parameters and options; as there, the dimension is unset unless
+// WithProjection fixed it, and a WithSeed generator restarts from its seed.
+// Map and slice storage is kept for the next build, which avoids
+// reallocating it.
+func (h *HNSW) Reset() {
+	h.clear()
+	if h.rng != nil {
+		h.rng.Seed(h.seed)
+	}
+}
+
+// clear empties the graph and element data, keeping the configuration and
+// the allocated storage. The dimension is kept only if WithProjection fixed
+// it.
+func (h *HNSW) clear() {
+	clear(h.Layers)
+	h.Layers = h.Layers[:0]
+	h.EnterPoint = -1
+	clear(h.Elements)
+	clear(h.Levels)
+	clear(h.IntVectors)
+	clear(h.HalfVectors)
+	clear(h.Deleted)
+	clear(h.Hidden)
+	if h.project == nil {
+		h.Dim = 0
+	}
+	clear(h.Labels)
+	clear(h.norms)
+	clear(h.labelIDs)
+	h.nextID = 0
+	h.freeIDs = h.freeIDs[:0]
+	h.insertOrder = h.insertOrder[:0]
+}
@@ -0,0 +1,76 @@
+package hnsw
+
+import "github.com/lblclass/hnswgo/models"
+
+// VectorStore serves the vectors of stored elements by ID. An index given
+// one with WithVectorStore or SetVectorStore reads every vector from it and
+// keeps only the graph and element metadata in memory, which allows
+// out-of-core search over vectors on disk; see hnswstore.MmapVectors. The
+// default, no store, keeps vectors in Elements.
+//
+// The store must hold the vector of an ID before the element is inserted,
+// equal to the Embeddings passed to Insert, and for as long as the element
+// is in the index. Get is called for every distance computation, possibly
+// from concurrent searches, and its result is only read.
+type VectorStore interface {
+	Get(id int) []float64
+}
+
+// SetVectorStore makes the index read vectors from vs, e.g. after Load,
+// since stores are not saved with the index. Elements inserted before keep
+// their in-memory vectors only until vs is set: from then on vs must serve
+// every stored ID. A nil vs switches back to Elements, which then have no
+// vectors for elements inserted while a store was set.
+func (h *HNSW) SetVectorStore(vs VectorStore) {
+	h.vectorStore = vs
+	h.rebuildNorms()
+}
+
+// store adds q to Elements and caches its norm for Cosine and Angular. The
+// vector is dropped when a VectorStore serves it, and moved to HalfVectors
+// when the index was created WithFloat16.
+func (h *HNSW) store(q models.Element) {
+	if h.vectorStore != nil {
+		q.Embeddings = nil
+	} else if h.Float16 {
+		h.HalfVectors[q.ID] = toHalf(q.Embeddings)
+		q.Embeddings = nil
+	}
+	h.Elements[q.ID] = q
+	if h.Metric.usesNorms() {
+		h.norms[q.ID] = h.norm(h.vector(q.ID))
+	}
+}
+
+// vector returns the stored vector of element id, widened from half
+// precision or integers if needed. The result must not be modified.
+func (h *HNSW) vector(id int) []float64 {
+	if h.Metric.isInt() {
+		v := h.IntVectors[id]
+		res := make([]float64, len(v))
+		for i, x := range v {
+			res[i] = float64(x)
+		}
+		return res
+	}
+	if h.vectorStore != nil {
+		return h.vectorStore.Get(id)
+	}
+	if h.Float16 {
+		return fromHalf(h.HalfVectors[id])
+	}
+	return h.Elements[id].Embeddings
+}
+
+// element returns the stored element id with its vector. A vector served
+// by a VectorStore is copied, as the store may own its memory.
+func (h *HNSW) element(id int) models.Element {
+	e := h.Elements[id]
+	switch {
+	case h.vectorStore != nil:
+		e.Embeddings = append([]float64(nil), h.vectorStore.Get(id)...)
+	case h.Float16:
+		e.Embeddings = fromHalf(h.HalfVectors[id])
+	}
+	return e
+}
@@ -1,10 +1,26 @@
 package models
 
+import "time"
+
 // Element represents an element in the HNSW graph.
 type Element struct {
 	ID         int
 	Embeddings []float64
 	Msg        string
+	GroupID    int       // Document the element belongs to, for grouped search
+	Payload    []byte    // Opaque data returned with the element; not used in distances
+	CreatedAt  time.Time // Creation time, for KNNSearchTimeRange; zero if not set
+}
+
+// IntElement is an element with integer embeddings, e.g. count features,
+// for indexes using an integer metric.
+type IntElement struct {
+	ID         int
+	Embeddings []int32
+	Msg        string
+	GroupID    int
+	Payload    []byte
+	CreatedAt  time.Time
 }
 
 // Candidate represents a node and its distance to the query point.
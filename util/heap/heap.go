@@ -14,25 +14,30 @@ const (
 // CandidateHeap is a generic heap for Candidates
 type CandidateHeap struct {
 	Candidates []models.Candidate
-	Compare    string // default min
+	Compare    string // BIG or SMALL (default); kept in sync with MaxHeap by the constructors, Fix and Len
+	MaxHeap    bool   // Farthest candidate on top; the only field Less reads
 }
 
-// Len is the number of elements in the collection
-func (ch CandidateHeap) Len() int { return len(ch.Candidates) }
+// Len is the number of elements in the collection. A heap built as a
+// literal with only Compare set to BIG gets MaxHeap set here, since
+// container/heap calls Len before any Less.
+func (ch *CandidateHeap) Len() int {
+	if !ch.MaxHeap && ch.Compare == BIG {
+		ch.MaxHeap = true
+	}
+	return len(ch.Candidates)
+}
 
-// Less reports whether the element with index i should sort before the element with index j
-func (ch CandidateHeap) Less(i, j int) bool {
-	res := 0.0
-	if ch.Compare == BIG {
-		res = (ch.Candidates[i].Distance - ch.Candidates[j].Distance)
-	} else {
-		res = (ch.Candidates[j].Distance - ch.Candidates[i].Distance)
+// Less reports whether the element with index i should sort before the element with index j.
+func (ch *CandidateHeap) Less(i, j int) bool {
+	if ch.MaxHeap {
+		return ch.Candidates[i].Distance > ch.Candidates[j].Distance
 	}
-	return res > 0.0
+	return ch.Candidates[i].Distance < ch.Candidates[j].Distance
 }
 
 // Swap swaps the elements with indexes i and j
-func (ch CandidateHeap) Swap(i, j int) {
+func (ch *CandidateHeap) Swap(i, j int) {
 	ch.Candidates[i], ch.Candidates[j] = ch.Candidates[j], ch.Candidates[i]
 }
 
@@ -50,6 +55,18 @@ func (ch *CandidateHeap) Pop() interface{} {
 	return item
 }
 
+// Fix re-establishes the heap invariant over Candidates, e.g. after the slice
+// was decoded or edited in bulk. Heaps that only carry Compare, such as
+// ones decoded from older files, get MaxHeap set to match.
+func (ch *CandidateHeap) Fix() {
+	if ch.Compare == BIG {
+		ch.MaxHeap = true
+	} else if ch.MaxHeap {
+		ch.Compare = BIG
+	}
+	heap.Init(ch)
+}
+
 // ExtractHeapData returns all node IDs in the heap without affecting the heap structure
 func (ch *CandidateHeap) ExtractHeapData() []int {
 	dataCopy := make([]int, len(ch.Candidates))
@@ -98,6 +115,7 @@ func (ch *CandidateHeap) TopKmaxVal(K int) []int {
 func NewCandidateHeap(compare string) *CandidateHeap {
 	return &CandidateHeap{
 		Compare: compare,
+		MaxHeap: compare == BIG,
 	}
 }
 
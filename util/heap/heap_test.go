@@ -0,0 +1,136 @@
+package hnswheap
+
+import (
+	"container/heap"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+var testDistances = []float64{3, 1, 4, 1.5, 9, 2.5, 6}
+
+// popDistances pops ch empty and returns the distances in pop order.
+func popDistances(ch *CandidateHeap) []float64 {
+	var res []float64
+	for ch.Len() > 0 {
+		res = append(res, heap.Pop(ch).(models.Candidate).Distance)
+	}
+	return res
+}
+
+// checkOrder fails unless dists is sorted, descending if desc.
+func checkOrder(t *testing.T, dists []float64, desc bool) {
+	t.Helper()
+	if len(dists) != len(testDistances) {
+		t.Fatalf("popped %d candidates, want %d", len(dists), len(testDistances))
+	}
+	for i := 1; i < len(dists); i++ {
+		if (desc && dists[i] > dists[i-1]) || (!desc && dists[i] < dists[i-1]) {
+			t.Fatalf("pop order %v is not sorted (descending %v)", dists, desc)
+		}
+	}
+}
+
+func TestCandidateHeapLiteral(t *testing.T) {
+	for _, tc := range []struct {
+		compare string
+		desc    bool
+	}{{BIG, true}, {SMALL, false}, {"", false}} {
+		ch := CandidateHeap{Compare: tc.compare}
+		for i, d := range testDistances {
+			ch.Candidates = append(ch.Candidates, models.Candidate{NodeID: i, Distance: d})
+		}
+		heap.Init(&ch)
+		if ch.MaxHeap != tc.desc {
+			t.Errorf("Compare %q: MaxHeap %v after heap.Init", tc.compare, ch.MaxHeap)
+		}
+		checkOrder(t, popDistances(&ch), tc.desc)
+	}
+}
+
+func TestCandidateHeapConstructors(t *testing.T) {
+	for _, tc := range []struct {
+		ch   *CandidateHeap
+		desc bool
+	}{{NewBigCandidatesHeap(), true}, {NewSmallCandidatesHeap(), false}} {
+		for i, d := range testDistances {
+			heap.Push(tc.ch, models.Candidate{NodeID: i, Distance: d})
+		}
+		checkOrder(t, popDistances(tc.ch), tc.desc)
+	}
+}
+
+func TestCandidateHeapJSON(t *testing.T) {
+	big, small := NewBigCandidatesHeap(), NewSmallCandidatesHeap()
+	for i, d := range testDistances {
+		heap.Push(big, models.Candidate{NodeID: i, Distance: d})
+		heap.Push(small, models.Candidate{NodeID: i, Distance: d})
+	}
+	data, err := json.Marshal(map[string]*CandidateHeap{"big": big, "small": small, "empty": NewBigCandidatesHeap()})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got map[string]*CandidateHeap
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		want *CandidateHeap
+		desc bool
+	}{"big": {big, true}, "small": {small, false}} {
+		ch := got[name]
+		if ch.Compare != tc.want.Compare || ch.MaxHeap != tc.desc {
+			t.Errorf("%s: Compare %q, MaxHeap %v after round trip", name, ch.Compare, ch.MaxHeap)
+		}
+		if !slices.Equal(ch.Candidates, tc.want.Candidates) {
+			t.Errorf("%s: Candidates %v, want %v", name, ch.Candidates, tc.want.Candidates)
+		}
+		checkOrder(t, popDistances(ch), tc.desc)
+	}
+	if e := got["empty"]; e.Compare != BIG || !e.MaxHeap || e.Len() != 0 {
+		t.Errorf("empty: %+v after round trip", e)
+	}
+}
+
+// Heaps written before MarshalJSON may hold unordered candidates or, if
+// max-heaps, only MaxHeap.
+func TestCandidateHeapUnmarshalJSONOld(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		desc bool
+	}{
+		{`{"Candidates":[{"NodeID":1,"Distance":1},{"NodeID":2,"Distance":9},{"NodeID":3,"Distance":4}],"Compare":"big"}`, true},
+		{`{"Candidates":[{"NodeID":1,"Distance":9},{"NodeID":2,"Distance":1},{"NodeID":3,"Distance":4}],"Compare":"small"}`, false},
+		{`{"Candidates":[{"NodeID":1,"Distance":1},{"NodeID":2,"Distance":9},{"NodeID":3,"Distance":4}],"MaxHeap":true}`, true},
+	} {
+		var ch CandidateHeap
+		if err := json.Unmarshal([]byte(tc.data), &ch); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", tc.data, err)
+		}
+		if top := ch.Candidates[0].Distance; (tc.desc && top != 9) || (!tc.desc && top != 1) {
+			t.Errorf("Unmarshal(%s): top distance %v", tc.data, top)
+		}
+	}
+}
+
+func TestMergeTopK(t *testing.T) {
+	results := [][]models.Candidate{
+		{{NodeID: 1, Distance: 1}, {NodeID: 3, Distance: 3}, {NodeID: 5, Distance: 5}},
+		{{NodeID: 2, Distance: 2}, {NodeID: 3, Distance: 2.5}, {NodeID: 6, Distance: 6}},
+		nil,
+	}
+	want := []models.Candidate{{NodeID: 1, Distance: 1}, {NodeID: 2, Distance: 2}, {NodeID: 3, Distance: 2.5}, {NodeID: 5, Distance: 5}}
+	if got := MergeTopK(results, 4); !slices.Equal(got, want) {
+		t.Errorf("MergeTopK(4) = %v, want %v", got, want)
+	}
+	if got := MergeTopK(results, 10); len(got) != 5 {
+		t.Errorf("MergeTopK(10) = %v, want the 5 distinct candidates", got)
+	}
+	for _, K := range []int{0, -1} {
+		if got := MergeTopK(results, K); len(got) != 0 {
+			t.Errorf("MergeTopK(%d) = %v, want none", K, got)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package hnswheap
+
+import (
+	"encoding/json"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// jsonHeap is the JSON form of a CandidateHeap. Compare is always written
+// explicitly; MaxHeap is derived from it and only read, from older files.
+type jsonHeap struct {
+	Candidates []models.Candidate
+	Compare    string
+	MaxHeap    bool `json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. The heap kind is written as
+// Compare, BIG or SMALL, so it cannot be lost to the SMALL default.
+func (ch CandidateHeap) MarshalJSON() ([]byte, error) {
+	compare := SMALL
+	if ch.MaxHeap || ch.Compare == BIG {
+		compare = BIG
+	}
+	candidates := ch.Candidates
+	if candidates == nil {
+		candidates = []models.Candidate{}
+	}
+	return json.Marshal(jsonHeap{Candidates: candidates, Compare: compare})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The decoded heap is ready to
+// use: its kind is restored from Compare and the heap invariant is
+// re-established, whatever the order of the stored candidates.
+func (ch *CandidateHeap) UnmarshalJSON(data []byte) error {
+	var j jsonHeap
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	ch.Candidates = j.Candidates
+	ch.Compare = j.Compare
+	ch.MaxHeap = j.MaxHeap
+	ch.Fix()
+	return nil
+}
@@ -0,0 +1,64 @@
+package hnswheap
+
+import (
+	"container/heap"
+
+	"github.com/lblclass/hnswgo/models"
+)
+
+// mergeCursor points at the next unread candidate of one result list
+type mergeCursor struct {
+	list int
+	pos  int
+	dist float64
+}
+
+// mergeHeap is a min-heap of cursors ordered by the distance they point at
+type mergeHeap []mergeCursor
+
+func (mh mergeHeap) Len() int            { return len(mh) }
+func (mh mergeHeap) Less(i, j int) bool  { return mh[i].dist < mh[j].dist }
+func (mh mergeHeap) Swap(i, j int)       { mh[i], mh[j] = mh[j], mh[i] }
+func (mh *mergeHeap) Push(x interface{}) { *mh = append(*mh, x.(mergeCursor)) }
+func (mh *mergeHeap) Pop() interface{} {
+	old := *mh
+	n := len(old)
+	item := old[n-1]
+	*mh = old[0 : n-1]
+	return item
+}
+
+// MergeTopK k-way merges candidate lists that are each sorted by ascending
+// distance (e.g. per-shard search results) into the global top K. A NodeID
+// present in several lists is kept once, with its smallest distance. It
+// returns nil if K <= 0.
+func MergeTopK(results [][]models.Candidate, K int) []models.Candidate {
+	if K <= 0 {
+		return nil
+	}
+	mh := make(mergeHeap, 0, len(results))
+	for i, r := range results {
+		if len(r) > 0 {
+			mh = append(mh, mergeCursor{list: i, pos: 0, dist: r[0].Distance})
+		}
+	}
+	heap.Init(&mh)
+
+	res := make([]models.Candidate, 0, K)
+	seen := make(map[int]bool)
+	for mh.Len() > 0 && len(res) < K {
+		cur := heap.Pop(&mh).(mergeCursor)
+		c := results[cur.list][cur.pos]
+		// Lists are popped in ascending order, so the first copy is the closest.
+		if !seen[c.NodeID] {
+			seen[c.NodeID] = true
+			res = append(res, c)
+		}
+		if cur.pos+1 < len(results[cur.list]) {
+			cur.pos++
+			cur.dist = results[cur.list][cur.pos].Distance
+			heap.Push(&mh, cur)
+		}
+	}
+	return res
+}
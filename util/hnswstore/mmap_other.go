@@ -0,0 +1,18 @@
+//go:build !unix
+
+package hnswstore
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of file into memory; there is no
+// mapping to release.
+func mapFile(file *os.File, size int) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(file, 0, int64(size)), data); err != nil {
+		return nil, nil, err
+	}
+	return data, nil, nil
+}
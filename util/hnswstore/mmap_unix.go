@@ -0,0 +1,17 @@
+//go:build unix
+
+package hnswstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of file read-only.
+func mapFile(file *os.File, size int) ([]byte, func() error, error) {
+	data, err := syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,162 @@
+package hnswstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"unsafe"
+)
+
+// Vector file format: a 16-byte header, the magic "HNV1", the dimension as
+// a little-endian uint32 and the row count as a little-endian uint64,
+// followed by the rows, each dim little-endian float64 values. Row i holds
+// the vector of element ID i.
+const headerSize = 16
+
+var vectorMagic = []byte("HNV1")
+
+// WriteVectorFile writes vectors to path in the vector file format, the
+// vector of ID i at row i. All vectors must have the same length.
+func WriteVectorFile(path string, vectors [][]float64) error {
+	dim := 0
+	if len(vectors) > 0 {
+		dim = len(vectors[0])
+	}
+	for i, v := range vectors {
+		if len(v) != dim {
+			return fmt.Errorf("hnswstore: vector %d has dimension %d, want %d", i, len(v), dim)
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := make([]byte, headerSize, headerSize+8*dim)
+	copy(buf, vectorMagic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(dim))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(len(vectors)))
+	if _, err := file.Write(buf); err != nil {
+		return err
+	}
+	for _, v := range vectors {
+		buf = buf[:0]
+		for _, x := range v {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(x))
+		}
+		if _, err := file.Write(buf); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// MmapVectors serves the vectors of a vector file from a read-only memory
+// mapping, so they are paged in from disk on access instead of being held
+// in memory. It implements hnsw.VectorStore. Vectors returned by Get alias
+// the mapping: they must not be modified, and not be used after Close.
+//
+// On platforms without mmap support, and on big-endian hosts, the file is
+// read into memory instead.
+type MmapVectors struct {
+	vecs  []float64
+	dim   int
+	count int
+	unmap func() error
+}
+
+// OpenMmapVectors maps the vector file at path.
+func OpenMmapVectors(path string) (*MmapVectors, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, fmt.Errorf("hnswstore: reading header: %w", err)
+	}
+	if string(header[:4]) != string(vectorMagic) {
+		return nil, errors.New("hnswstore: not a vector file")
+	}
+	dim := int(binary.LittleEndian.Uint32(header[4:]))
+	count := int(binary.LittleEndian.Uint64(header[8:]))
+	size := headerSize + 8*dim*count
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != int64(size) {
+		return nil, fmt.Errorf("hnswstore: file has %d bytes, header implies %d", info.Size(), size)
+	}
+
+	m := &MmapVectors{dim: dim, count: count}
+	if dim*count == 0 {
+		return m, nil
+	}
+	data, unmap, err := mapFile(file, size)
+	if err != nil {
+		return nil, err
+	}
+	rows := data[headerSize:]
+	if littleEndian && unmap != nil {
+		// The mapping is page-aligned and the header keeps rows 8-byte
+		// aligned, so the rows can be viewed in place.
+		m.vecs = unsafe.Slice((*float64)(unsafe.Pointer(&rows[0])), dim*count)
+		m.unmap = unmap
+		return m, nil
+	}
+	m.vecs = make([]float64, dim*count)
+	for i := range m.vecs {
+		m.vecs[i] = math.Float64frombits(binary.LittleEndian.Uint64(rows[8*i:]))
+	}
+	if unmap != nil {
+		if err := unmap(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Get returns the vector of ID id, or nil if the file has no such row.
+func (m *MmapVectors) Get(id int) []float64 {
+	if id < 0 || id >= m.count {
+		return nil
+	}
+	start := id * m.dim
+	return m.vecs[start : start+m.dim : start+m.dim]
+}
+
+// Dim returns the vector dimension.
+func (m *MmapVectors) Dim() int {
+	return m.dim
+}
+
+// Len returns the number of rows.
+func (m *MmapVectors) Len() int {
+	return m.count
+}
+
+// Close releases the mapping.
+func (m *MmapVectors) Close() error {
+	m.vecs = nil
+	m.count = 0
+	if m.unmap == nil {
+		return nil
+	}
+	unmap := m.unmap
+	m.unmap = nil
+	return unmap()
+}
+
+// littleEndian reports whether the host stores integers little-endian, the
+// byte order of vector files.
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()